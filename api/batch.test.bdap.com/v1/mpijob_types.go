@@ -18,22 +18,592 @@ package v1
 
 import (
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// WorkerMode describes how the worker pods of an MPIJob are managed.
+// +kubebuilder:validation:Enum=StatefulSet;Pods
+type WorkerMode string
+
+const (
+	// WorkerModeStatefulSet runs the workers in a StatefulSet owned by the MPIJob.
+	WorkerModeStatefulSet WorkerMode = "StatefulSet"
+	// WorkerModePods creates and owns one Pod per worker directly, so the
+	// controller decides when a single rank is recreated.
+	WorkerModePods WorkerMode = "Pods"
+)
+
+// WorkerCommandPolicy is what the controller does with the command of the
+// worker container.
+// +kubebuilder:validation:Enum=Keep;Replace;Wrap
+type WorkerCommandPolicy string
+
+const (
+	// WorkerCommandKeep runs the command of the template as it is. It must
+	// keep running until the launcher is done, e.g. with sleep infinity.
+	WorkerCommandKeep WorkerCommandPolicy = "Keep"
+	// WorkerCommandReplace runs the keep-alive entrypoint of the controller
+	// instead of the command of the template.
+	WorkerCommandReplace WorkerCommandPolicy = "Replace"
+	// WorkerCommandWrap runs the command of the template first, as a setup
+	// step, and then the keep-alive entrypoint.
+	WorkerCommandWrap WorkerCommandPolicy = "Wrap"
+)
+
+// WorkerGroup is a set of identical workers. Each group is rendered as its
+// own StatefulSet, or its own set of Pods in the Pods worker mode.
+type WorkerGroup struct {
+	// Name of the group. The workers of the group are named
+	// <job>-worker-<name>-<index>.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	Template v1.PodTemplateSpec `json:"template"`
+
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Slots is the number of slots of every worker of the group in the hostfile.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Slots int32 `json:"slots,omitempty"`
+}
+
+// NetworkIsolation restricts the traffic of the pods of an MPIJob to the
+// other pods of the MPIJob.
+type NetworkIsolation struct {
+	// AllowDNS allows the pods to reach DNS servers on port 53. A pointer,
+	// so false is kept instead of being defaulted to true.
+	// +kubebuilder:default=true
+	// +optional
+	AllowDNS *bool `json:"allowDNS,omitempty"`
+
+	// Egress lists the other destinations the pods may reach, e.g. storage.
+	// +optional
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// WorkspaceRetentionPolicy is what happens to the workspace of an MPIJob
+// when it finishes.
+// +kubebuilder:validation:Enum=Delete;Retain
+type WorkspaceRetentionPolicy string
+
+const (
+	// WorkspaceRetentionDelete deletes the workspace when the MPIJob finishes.
+	WorkspaceRetentionDelete WorkspaceRetentionPolicy = "Delete"
+	// WorkspaceRetentionRetain keeps the workspace until the MPIJob is deleted.
+	WorkspaceRetentionRetain WorkspaceRetentionPolicy = "Retain"
+)
+
+// Workspace is a volume shared by the launcher and the workers of an MPIJob.
+type Workspace struct {
+	// MountPath is where the workspace is mounted in all the containers of
+	// the launcher and the workers.
+	// +kubebuilder:default=/workspace
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// VolumeClaimSpec is the spec of the PersistentVolumeClaim of the
+	// workspace. The access modes default to ReadWriteMany.
+	VolumeClaimSpec v1.PersistentVolumeClaimSpec `json:"volumeClaimSpec"`
+
+	// RetentionPolicy defaults to Delete.
+	// +kubebuilder:default=Delete
+	// +optional
+	RetentionPolicy WorkspaceRetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// GitSource is a revision of a git repository.
+type GitSource struct {
+	URL string `json:"url"`
+
+	// Revision is a branch, a tag or a commit. Defaults to the HEAD of the
+	// repository.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+}
+
+// ArchiveSource is a tar.gz archive.
+type ArchiveSource struct {
+	URL string `json:"url"`
+}
+
+// ConfigMapSource is a ConfigMap whose keys are the files of the source.
+type ConfigMapSource struct {
+	Name string `json:"name"`
+}
+
+// Source is the code of an MPIJob, fetched before the launcher and the
+// workers start. Exactly one of Git, Archive and ConfigMap must be set.
+type Source struct {
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+
+	// +optional
+	Archive *ArchiveSource `json:"archive,omitempty"`
+
+	// +optional
+	ConfigMap *ConfigMapSource `json:"configMap,omitempty"`
+
+	// MountPath is where the source is in all the containers of the launcher
+	// and the workers.
+	// +kubebuilder:default=/src
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// Image of the init container that fetches the source. It needs git, or
+	// wget and tar for an archive.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// WorkerReadiness configures the readiness probe the controller adds to the
+// workers. The launcher is only created once all the workers are ready.
+type WorkerReadiness struct {
+	// Disabled leaves the workers without the probe. The containers that
+	// have a readinessProbe in the template keep theirs anyway.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Command is a shell command the probe runs in the worker container, e.g.
+	// to check that a setup step is done. Without it, the probe checks that
+	// the shell the rsh agent runs the commands with finds the daemon of
+	// mpirun, orted or hydra_pmi_proxy. A launcher
+	// that runs as a worker runs it in an init container until it passes.
+	// +optional
+	Command string `json:"command,omitempty"`
+
+	// PeriodSeconds of the probe.
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+}
+
+// Preflight is a check, before the launcher is created, that the rsh agent
+// can run a command in every host of the hostfile.
+type Preflight struct {
+	// TimeoutSeconds bounds the check of every host.
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// BackoffLimit is the number of failed checks of a run after which the
+	// MPIJob fails.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	BackoffLimit int32 `json:"backoffLimit,omitempty"`
+}
+
+// WorkerUpdatePolicy is when the changes of the worker templates reach the
+// workers while the launcher is running.
+// +kubebuilder:validation:Enum=Deferred;Restart
+type WorkerUpdatePolicy string
+
+const (
+	// WorkerUpdateDeferred holds the changes until the launcher has ended.
+	WorkerUpdateDeferred WorkerUpdatePolicy = "Deferred"
+	// WorkerUpdateRestart applies the changes right away, by restarting the
+	// launcher and all the workers together.
+	WorkerUpdateRestart WorkerUpdatePolicy = "Restart"
+)
+
+// FailureAction is what the controller does when the launcher fails.
+// +kubebuilder:validation:Enum=Retry;FailJob;Ignore
+type FailureAction string
+
+const (
+	// FailureActionRetry restarts the launcher and the workers, up to the
+	// retry limit of the failure policy.
+	FailureActionRetry FailureAction = "Retry"
+	// FailureActionFailJob fails the MPIJob.
+	FailureActionFailJob FailureAction = "FailJob"
+	// FailureActionIgnore restarts the launcher and the workers without
+	// counting the failure against the retry limit.
+	FailureActionIgnore FailureAction = "Ignore"
+)
+
+// FailurePolicyRule matches a failure of the launcher when all the criteria
+// it sets match.
+type FailurePolicyRule struct {
+	Action FailureAction `json:"action"`
+
+	// OnExitCodes matches the exit code of the launcher container.
+	// +optional
+	OnExitCodes []int32 `json:"onExitCodes,omitempty"`
+
+	// OnReasons matches the termination reason of the launcher container,
+	// e.g. OOMKilled, or the reason of the launcher pod, e.g. Evicted.
+	// +optional
+	OnReasons []string `json:"onReasons,omitempty"`
+
+	// OnPodConditions matches the true conditions of the launcher pod, e.g.
+	// DisruptionTarget.
+	// +optional
+	OnPodConditions []v1.PodConditionType `json:"onPodConditions,omitempty"`
+}
+
+// FailurePolicy decides what happens when the launcher fails. The first rule
+// that matches the failure applies. A failure no rule matches fails the
+// MPIJob.
+type FailurePolicy struct {
+	// +optional
+	Rules []FailurePolicyRule `json:"rules,omitempty"`
+
+	// MaxRetries is the number of failures the Retry action restarts.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRetries int32 `json:"maxRetries,omitempty"`
+}
+
+// GracefulShutdown is how the controller asks a running launcher to
+// checkpoint before it deletes the pods of a suspended or deleted MPIJob.
+type GracefulShutdown struct {
+	// Signal is sent to the process 1 of the container of the launcher the
+	// commands of the rsh agent run in, the first one by default, e.g.
+	// SIGUSR1.
+	// +kubebuilder:default=SIGTERM
+	// +kubebuilder:validation:Pattern=`^(SIG)?[A-Z0-9]+$`
+	// +optional
+	Signal string `json:"signal,omitempty"`
+
+	// Command, when set, is run in that container of the launcher instead
+	// of sending the signal. It is given up on after the grace period.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// Workers, when true, also signals the workers, or runs the command in
+	// them, in the container the launcher execs into.
+	// +optional
+	Workers bool `json:"workers,omitempty"`
+
+	// MarkerPath is a file the launcher creates once the checkpoint is
+	// complete. Without it, the controller waits for the launcher to end.
+	// +optional
+	MarkerPath string `json:"markerPath,omitempty"`
+
+	// GracePeriodSeconds is how long the controller waits for the marker, or
+	// for the launcher to end, before it deletes the pods anyway.
+	// +kubebuilder:default=60
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	GracePeriodSeconds int32 `json:"gracePeriodSeconds,omitempty"`
+}
+
+// RunPolicy is how the controller runs an MPIJob.
+type RunPolicy struct {
+	// WorkerStartupTimeoutSeconds fails the MPIJob when its workers are not
+	// all ready this long after the controller started them.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	WorkerStartupTimeoutSeconds *int32 `json:"workerStartupTimeoutSeconds,omitempty"`
+
+	// NodeFailureTolerationSeconds, when set, replaces the workers whose node
+	// has not been ready this long, and the evicted or preempted workers, and
+	// restarts the launcher with them.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	NodeFailureTolerationSeconds *int32 `json:"nodeFailureTolerationSeconds,omitempty"`
+
+	// WorkerUpdatePolicy defaults to Deferred. The worker StatefulSets never
+	// roll their pods out by themselves, so the MPI world of a running
+	// launcher is not broken by an update.
+	// +kubebuilder:default=Deferred
+	// +optional
+	WorkerUpdatePolicy WorkerUpdatePolicy `json:"workerUpdatePolicy,omitempty"`
+
+	// FailurePolicy, when set, can restart the MPIJob when its launcher
+	// fails, depending on how it failed.
+	// +optional
+	FailurePolicy *FailurePolicy `json:"failurePolicy,omitempty"`
+
+	// GracefulShutdown, when set, lets a running launcher checkpoint before
+	// the MPIJob is suspended or deleted. A finalizer holds the deletion of
+	// the MPIJob until then.
+	// +optional
+	GracefulShutdown *GracefulShutdown `json:"gracefulShutdown,omitempty"`
+}
+
+// Checkpoint tells the launcher and the workers where to keep their
+// checkpoints, to resume the training when the MPIJob is restarted.
+type Checkpoint struct {
+	// Path of the checkpoints, e.g. in the workspace, which is kept across
+	// the attempts of the MPIJob.
+	Path string `json:"path"`
+
+	// EnvName is the environment variable set to the path.
+	// +kubebuilder:default=MPIJOB_CHECKPOINT_PATH
+	// +optional
+	EnvName string `json:"envName,omitempty"`
+}
+
 // MPIJobSpec defines the desired state of MPIJob
 type MPIJobSpec struct {
 	LauncherTemplate v1.PodTemplateSpec `json:"launcherTemplate"`
 
-	WorkerTemplate v1.PodTemplateSpec `json:"workerTemplate"`
+	// WorkerTemplate and NumWorkers describe a single group of workers.
+	// They are ignored when WorkerGroups is set.
+	// +optional
+	WorkerTemplate v1.PodTemplateSpec `json:"workerTemplate,omitempty"`
+
+	// +optional
+	NumWorkers *int32 `json:"numWorkers,omitempty"`
+
+	// WorkerGroups lists heterogeneous groups of workers. The ranks in the
+	// hostfile follow the order of this list.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	WorkerGroups []WorkerGroup `json:"workerGroups,omitempty"`
+
+	// RunLauncherAsWorker lists the launcher pod first in the hostfile, so it
+	// runs rank 0 of the training itself instead of only running mpirun.
+	// +optional
+	RunLauncherAsWorker bool `json:"runLauncherAsWorker,omitempty"`
+
+	// LauncherSlots is the number of slots of the launcher in the hostfile
+	// when RunLauncherAsWorker is set.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	LauncherSlots int32 `json:"launcherSlots,omitempty"`
+
+	// Suspend deletes the launcher and the workers of the MPIJob while it is
+	// true. They are created again once it is set back to false.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// WorkerMode selects how worker pods are created. Defaults to StatefulSet.
+	// +kubebuilder:default=StatefulSet
+	// +optional
+	WorkerMode WorkerMode `json:"workerMode,omitempty"`
+
+	// NetworkIsolation, when set, makes the controller create NetworkPolicies
+	// that only allow traffic among the launcher and the workers of the
+	// MPIJob, and the egress listed in it.
+	// +optional
+	NetworkIsolation *NetworkIsolation `json:"networkIsolation,omitempty"`
+
+	// Workspace, when set, makes the controller create a
+	// PersistentVolumeClaim, <job>-workspace, mounted in the launcher and all
+	// the workers.
+	// +optional
+	Workspace *Workspace `json:"workspace,omitempty"`
+
+	// Source, when set, is fetched by an init container of the launcher and
+	// of every worker, so they all run the same code.
+	// +optional
+	Source *Source `json:"source,omitempty"`
+
+	// WorkerReadiness configures the readiness probe the controller adds to
+	// the workers. The probe is added unless it is disabled.
+	// +optional
+	WorkerReadiness *WorkerReadiness `json:"workerReadiness,omitempty"`
+
+	// WorkerCommandPolicy selects whether the worker container runs the
+	// keep-alive entrypoint of the controller, which exits when the MPIJob is
+	// done. Defaults to Keep.
+	// +kubebuilder:default=Keep
+	// +optional
+	WorkerCommandPolicy WorkerCommandPolicy `json:"workerCommandPolicy,omitempty"`
+
+	// Preflight, when set, makes the controller run a short <job>-preflight
+	// pod that checks every host of the hostfile before the launcher is
+	// created. The launcher is only created once all the hosts passed.
+	// +optional
+	Preflight *Preflight `json:"preflight,omitempty"`
+
+	// +optional
+	RunPolicy RunPolicy `json:"runPolicy,omitempty"`
+
+	// Checkpoint, when set, adds the path of the checkpoints to the
+	// environment of the launcher and the workers.
+	// +optional
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// WorkerStatus is the observed state of a single worker pod.
+type WorkerStatus struct {
+	Name string `json:"name"`
+
+	Phase v1.PodPhase `json:"phase,omitempty"`
+
+	Ready bool `json:"ready,omitempty"`
+
+	// Restarts is the number of container restarts of the pod.
+	Restarts int32 `json:"restarts,omitempty"`
+
+	// Reason is why the pod can't start, e.g. Unschedulable,
+	// ImagePullBackOff or CrashLoopBackOff.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// LauncherStatus is the observed state of the launcher pod. The termination
+// details are captured when the launcher ends.
+type LauncherStatus struct {
+	Phase v1.PodPhase `json:"phase,omitempty"`
+
+	// UID of the launcher pod.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+
+	// ExitCode of the launcher container.
+	// +optional
+	ExitCode *int32 `json:"exitCode,omitempty"`
 
-	NumWorkers *int32 `json:"numWorkers"`
+	// Reason is the termination reason of the launcher container, e.g. Error or OOMKilled.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is the terminationMessage of the launcher container.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LogTail holds the last lines of the launcher log when it failed.
+	// +optional
+	LogTail string `json:"logTail,omitempty"`
+
+	// UnhealthyWorkers lists the workers that were not ready or had restarted
+	// when the launcher failed.
+	// +optional
+	UnhealthyWorkers []WorkerStatus `json:"unhealthyWorkers,omitempty"`
+}
+
+const (
+	// ConditionSucceeded is set when the launcher pod has succeeded.
+	ConditionSucceeded = "Succeeded"
+	// ConditionFailed is set when the launcher pod has failed.
+	ConditionFailed = "Failed"
+	// ConditionSuspended is set while the MPIJob is suspended.
+	ConditionSuspended = "Suspended"
+	// ConditionResourceConflict is set while an object with the name of a
+	// child object exists but is not controlled by the MPIJob. The MPIJob is
+	// not reconciled until the object is removed.
+	ConditionResourceConflict = "ResourceConflict"
+	// ConditionPreflightPassed is set when the preflight check has run. It
+	// is false while some hosts fail the check.
+	ConditionPreflightPassed = "PreflightPassed"
+	// ConditionWorkersReady is true when all the workers are ready. While
+	// it is false, its reason is the first reason why a worker can't start.
+	ConditionWorkersReady = "WorkersReady"
+)
+
+// PreflightHostStatus is the result of the preflight check of a host.
+type PreflightHostStatus struct {
+	Name string `json:"name"`
+
+	Passed bool `json:"passed"`
+
+	// LatencyMilliseconds is the time it took to run the check command.
+	// +optional
+	LatencyMilliseconds int64 `json:"latencyMilliseconds,omitempty"`
+
+	// Message is the error of a failed check.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// PreflightStatus is the result of the last preflight check.
+type PreflightStatus struct {
+	Passed bool `json:"passed"`
+
+	// Failures is the number of failed checks of the current run.
+	// +optional
+	Failures int32 `json:"failures,omitempty"`
+
+	// +optional
+	Hosts []PreflightHostStatus `json:"hosts,omitempty"`
+}
+
+// SourceStatus is the revision of the git source all the pods check out.
+type SourceStatus struct {
+	URL string `json:"url"`
+
+	Revision string `json:"revision,omitempty"`
+
+	// Commit is the commit the revision was checked out at by the first pod,
+	// which the other pods check out too.
+	Commit string `json:"commit"`
 }
 
 // MPIJobStatus defines the observed state of MPIJob
 type MPIJobStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Launcher is the observed state of the launcher pod.
+	// +optional
+	Launcher *LauncherStatus `json:"launcher,omitempty"`
+
+	// ReadyWorkers is the number of ready worker pods.
+	// +optional
+	ReadyWorkers int32 `json:"readyWorkers,omitempty"`
+
+	// Workers lists the worker pods managed directly by the controller.
+	// It is only populated when WorkerMode is Pods.
+	// +optional
+	Workers []WorkerStatus `json:"workers,omitempty"`
+
+	// StartTime is when the controller started the workers, the last time
+	// the MPIJob was created or resumed.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Attempt is the number of the current run of the MPIJob, starting at 1.
+	// It is increased when the launcher and the workers are restarted, or
+	// when the MPIJob is suspended, before the pods of the next attempt are
+	// created.
+	// +optional
+	Attempt int32 `json:"attempt,omitempty"`
+
+	// RestartReason is why the current attempt was started, e.g.
+	// LauncherFailed, WorkerUpdate or Suspended. It is empty for the first
+	// attempt.
+	// +optional
+	RestartReason string `json:"restartReason,omitempty"`
+
+	// ShutdownTime is when the controller asked the launcher to shut down
+	// gracefully. It is recorded before the signal is sent, so the signal is
+	// sent once.
+	// +optional
+	ShutdownTime *metav1.Time `json:"shutdownTime,omitempty"`
+
+	// Retries is the number of failures of the launcher restarted by the
+	// Retry action of the failure policy.
+	// +optional
+	Retries int32 `json:"retries,omitempty"`
+
+	// LastFailure is the state of the last failed launcher that was
+	// restarted by the failure policy.
+	// +optional
+	LastFailure *LauncherStatus `json:"lastFailure,omitempty"`
+
+	// WorkerUpdatePending is true while some worker pods don't match the
+	// template of their StatefulSet, because the update is held until the
+	// launcher has ended.
+	// +optional
+	WorkerUpdatePending bool `json:"workerUpdatePending,omitempty"`
+
+	// Selector is the label selector of all the pods of the MPIJob.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Source is the commit of the git source of the MPIJob.
+	// +optional
+	Source *SourceStatus `json:"source,omitempty"`
+
+	// Preflight is the result of the last preflight check.
+	// +optional
+	Preflight *PreflightStatus `json:"preflight,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// deleteJob deletes an MPIJob. With --wait, the deletion is done in the
+// foreground and the command returns once the MPIJob and all its children
+// are gone.
+func deleteJob(args []string) error {
+	fs, o := newFlagSet("delete")
+	var waitDeleted bool
+	var timeout time.Duration
+	fs.BoolVar(&waitDeleted, "wait", false, "Wait until the MPIJob and all its children are deleted.")
+	fs.DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait with --wait.")
+	name, err := parseName(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+
+	opts := metav1.DeleteOptions{}
+	if waitDeleted {
+		// the MPIJob stays until the garbage collector has deleted its children
+		foreground := metav1.DeletePropagationForeground
+		opts.PropagationPolicy = &foreground
+	}
+	mpiJobs := c.mpi.BatchV1().MPIJobs(c.namespace)
+	if err := mpiJobs.Delete(context.TODO(), name, opts); err != nil {
+		return err
+	}
+	if waitDeleted {
+		err := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+			_, err := mpiJobs.Get(context.TODO(), name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return fmt.Errorf("waiting for mpijob/%s to be deleted: %w", name, err)
+		}
+	}
+	fmt.Printf("mpijob/%s deleted\n", name)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/batch.test.bdap.com/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// child is an object created by the controller for an MPIJob.
+type child struct {
+	kind   string
+	name   string
+	status string
+}
+
+// describe prints the status of an MPIJob, its children, its hostfile and
+// the events of all of them.
+func describe(args []string) error {
+	fs, o := newFlagSet("describe")
+	name, err := parseName(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	mpiJob, err := c.mpi.BatchV1().MPIJobs(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	children, uids, err := listChildren(ctx, c, mpiJob)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", mpiJob.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", mpiJob.Namespace)
+	fmt.Fprintf(w, "Age:\t%s\n", age(mpiJob.CreationTimestamp))
+	fmt.Fprintf(w, "Phase:\t%s\n", phase(mpiJob))
+	fmt.Fprintf(w, "Workers:\t%d/%d ready\n", mpiJob.Status.ReadyWorkers, numWorkers(mpiJob))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nConditions:")
+	if len(mpiJob.Status.Conditions) == 0 {
+		fmt.Println("  <none>")
+	} else {
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+		for _, cond := range mpiJob.Status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", cond.Type, cond.Status, cond.Reason, cond.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if launcher := mpiJob.Status.Launcher; launcher != nil {
+		fmt.Println("\nLauncher:")
+		fmt.Fprintf(w, "  Phase:\t%s\n", launcher.Phase)
+		if launcher.ExitCode != nil {
+			fmt.Fprintf(w, "  Exit Code:\t%d\n", *launcher.ExitCode)
+		}
+		if launcher.Reason != "" {
+			fmt.Fprintf(w, "  Reason:\t%s\n", launcher.Reason)
+		}
+		if launcher.Message != "" {
+			fmt.Fprintf(w, "  Message:\t%s\n", strings.TrimSpace(launcher.Message))
+		}
+		for _, worker := range launcher.UnhealthyWorkers {
+			fmt.Fprintf(w, "  Unhealthy Worker:\t%s (ready: %t, restarts: %d)\n", worker.Name, worker.Ready, worker.Restarts)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if launcher.LogTail != "" {
+			fmt.Println("  Log Tail:")
+			printIndented(os.Stdout, launcher.LogTail, "    ")
+		}
+	}
+
+	fmt.Println("\nChildren:")
+	fmt.Fprintln(w, "  KIND\tNAME\tSTATUS")
+	for _, ch := range children {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", ch.kind, ch.name, ch.status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nHostfile:")
+	cm, err := c.kube.CoreV1().ConfigMaps(c.namespace).Get(ctx, mpiJob.Name+"-config", metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		fmt.Println("  <none>")
+	case err != nil:
+		return err
+	default:
+		printIndented(os.Stdout, cm.Data["hostfile"], "  ")
+	}
+
+	fmt.Println("\nEvents:")
+	events, err := c.kube.CoreV1().Events(c.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var related []corev1.Event
+	for _, event := range events.Items {
+		if uids[event.InvolvedObject.UID] {
+			related = append(related, event)
+		}
+	}
+	if len(related) == 0 {
+		fmt.Println("  <none>")
+		return nil
+	}
+	sort.Slice(related, func(i, j int) bool {
+		return related[i].LastTimestamp.Before(&related[j].LastTimestamp)
+	})
+	fmt.Fprintln(w, "  LAST SEEN\tTYPE\tREASON\tOBJECT\tMESSAGE")
+	for _, event := range related {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s/%s\t%s\n", age(event.LastTimestamp), event.Type, event.Reason,
+			strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name, strings.TrimSpace(event.Message))
+	}
+	return w.Flush()
+}
+
+// listChildren returns the objects controlled by an MPIJob, including the
+// pods of its worker StatefulSets, and the UIDs of the MPIJob and all of them.
+func listChildren(ctx context.Context, c *clients, mpiJob *v1.MPIJob) ([]child, map[types.UID]bool, error) {
+	var children []child
+	uids := map[types.UID]bool{mpiJob.UID: true}
+	controlledBy := func(obj metav1.Object) bool {
+		ref := metav1.GetControllerOf(obj)
+		return ref != nil && uids[ref.UID]
+	}
+	add := func(obj metav1.Object, kind, status string) {
+		children = append(children, child{kind: kind, name: obj.GetName(), status: status})
+		uids[obj.GetUID()] = true
+	}
+	opts := metav1.ListOptions{}
+
+	configMaps, err := c.kube.CoreV1().ConfigMaps(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range configMaps.Items {
+		if controlledBy(&configMaps.Items[i]) {
+			add(&configMaps.Items[i], "ConfigMap", "-")
+		}
+	}
+	serviceAccounts, err := c.kube.CoreV1().ServiceAccounts(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range serviceAccounts.Items {
+		if controlledBy(&serviceAccounts.Items[i]) {
+			add(&serviceAccounts.Items[i], "ServiceAccount", "-")
+		}
+	}
+	roles, err := c.kube.RbacV1().Roles(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range roles.Items {
+		if controlledBy(&roles.Items[i]) {
+			add(&roles.Items[i], "Role", "-")
+		}
+	}
+	roleBindings, err := c.kube.RbacV1().RoleBindings(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range roleBindings.Items {
+		if controlledBy(&roleBindings.Items[i]) {
+			add(&roleBindings.Items[i], "RoleBinding", "-")
+		}
+	}
+	statefulSets, err := c.kube.AppsV1().StatefulSets(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range statefulSets.Items {
+		sts := &statefulSets.Items[i]
+		if controlledBy(sts) {
+			var replicas int32
+			if sts.Spec.Replicas != nil {
+				replicas = *sts.Spec.Replicas
+			}
+			add(sts, "StatefulSet", fmt.Sprintf("%d/%d ready", sts.Status.ReadyReplicas, replicas))
+		}
+	}
+	// the pods come last, as they may be controlled by the StatefulSets above
+	pods, err := c.kube.CoreV1().Pods(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if controlledBy(pod) {
+			add(pod, "Pod", podStatus(pod))
+		}
+	}
+	return children, uids, nil
+}
+
+func podStatus(pod *corev1.Pod) string {
+	ready := false
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			ready = cond.Status == corev1.ConditionTrue
+		}
+	}
+	var restarts int32
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarts += cs.RestartCount
+	}
+	return fmt.Sprintf("%s, ready: %t, restarts: %d", pod.Status.Phase, ready, restarts)
+}
+
+func printIndented(w io.Writer, text, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintln(w, indent+line)
+	}
+}
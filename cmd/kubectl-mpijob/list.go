@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/batch.test.bdap.com/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// list prints the MPIJobs with their phase and the readiness of their workers.
+func list(args []string) error {
+	fs, o := newFlagSet("list")
+	var allNamespaces bool
+	fs.BoolVar(&allNamespaces, "A", false, "List the MPIJobs of all namespaces.")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	namespace := c.namespace
+	if allNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	mpiJobs, err := c.mpi.BatchV1().MPIJobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	if allNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tPHASE\tWORKERS\tAGE")
+	for i := range mpiJobs.Items {
+		mpiJob := &mpiJobs.Items[i]
+		if allNamespaces {
+			fmt.Fprintf(w, "%s\t", mpiJob.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", mpiJob.Name, phase(mpiJob),
+			mpiJob.Status.ReadyWorkers, numWorkers(mpiJob), age(mpiJob.CreationTimestamp))
+	}
+	return w.Flush()
+}
+
+// phase summarizes the conditions and the launcher status of an MPIJob.
+func phase(mpiJob *v1.MPIJob) string {
+	switch {
+	case meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionSuspended):
+		return "Suspended"
+	case meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionSucceeded):
+		return "Succeeded"
+	case meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionFailed):
+		return "Failed"
+	case mpiJob.Status.Launcher != nil:
+		return "Running"
+	default:
+		return "Pending"
+	}
+}
+
+// numWorkers returns the number of workers of all worker groups of an MPIJob.
+func numWorkers(mpiJob *v1.MPIJob) int32 {
+	if len(mpiJob.Spec.WorkerGroups) == 0 {
+		if mpiJob.Spec.NumWorkers == nil {
+			return 0
+		}
+		return *mpiJob.Spec.NumWorkers
+	}
+	var n int32
+	for _, group := range mpiJob.Spec.WorkerGroups {
+		n += group.Replicas
+	}
+	return n
+}
+
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// logs prints the log of the launcher of an MPIJob, or of the worker running
+// a given rank.
+func logs(args []string) error {
+	fs, o := newFlagSet("logs")
+	var rank int
+	var container string
+	var follow bool
+	var tail int64
+	fs.IntVar(&rank, "worker", -1, "Print the log of the worker running this rank instead of the launcher.")
+	fs.StringVar(&container, "c", "", "Container to print the log of. Defaults to the first container.")
+	fs.BoolVar(&follow, "f", false, "Follow the log.")
+	fs.Int64Var(&tail, "tail", -1, "Number of lines to print from the end of the log, all if negative.")
+	name, err := parseName(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	podName := name + "-launcher"
+	if rank >= 0 {
+		cm, err := c.kube.CoreV1().ConfigMaps(c.namespace).Get(ctx, name+"-config", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if podName, err = rankHost(cm.Data["hostfile"], rank); err != nil {
+			return err
+		}
+	}
+
+	opts := &corev1.PodLogOptions{Container: container, Follow: follow}
+	if tail >= 0 {
+		opts.TailLines = &tail
+	}
+	stream, err := c.kube.CoreV1().Pods(c.namespace).GetLogs(podName, opts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(os.Stdout, stream)
+	return err
+}
+
+// rankHost returns the host of the hostfile running a rank. The ranks are
+// assigned to the hosts in order, as many as each host has slots.
+func rankHost(hostfile string, rank int) (string, error) {
+	first := 0
+	for _, line := range strings.Split(hostfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		slots := 1
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "slots=") {
+				n, err := strconv.Atoi(strings.TrimPrefix(field, "slots="))
+				if err != nil {
+					return "", fmt.Errorf("invalid hostfile line %q", line)
+				}
+				slots = n
+			}
+		}
+		if rank < first+slots {
+			return fields[0], nil
+		}
+		first += slots
+	}
+	return "", fmt.Errorf("rank %d is not in the hostfile, which has %d slots", rank, first)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-mpijob is a kubectl plugin to submit, inspect and manage MPIJobs.
+// Put it in the PATH and run it as `kubectl mpijob <command>`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	"github.com/FFFFFaraway/MPI-Operator/client/clientset/versioned"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const usage = `kubectl mpijob manages MPIJobs.
+
+Usage:
+  kubectl mpijob submit -f FILE
+  kubectl mpijob list [-A]
+  kubectl mpijob describe NAME
+  kubectl mpijob logs NAME [--worker RANK] [-c CONTAINER] [-f] [--tail N]
+  kubectl mpijob suspend NAME
+  kubectl mpijob resume NAME
+  kubectl mpijob delete NAME [--wait] [--timeout DURATION]
+  kubectl mpijob render -f FILE [--agent-image IMAGE] [--exec-proxy-url URL]
+
+All commands accept -n/--namespace and --kubeconfig.
+`
+
+var commands = map[string]func(args []string) error{
+	"submit":   submit,
+	"list":     list,
+	"describe": describe,
+	"logs":     logs,
+	"suspend":  func(args []string) error { return setSuspend("suspend", args, true) },
+	"resume":   func(args []string) error { return setSuspend("resume", args, false) },
+	"delete":   deleteJob,
+	"render":   renderJob,
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// options holds the flags shared by all the commands.
+type options struct {
+	kubeconfig string
+	namespace  string
+}
+
+// clients holds the clients of a command, and the namespace it works in.
+type clients struct {
+	mpi       versioned.Interface
+	kube      kubernetes.Interface
+	namespace string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	o := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the MPIJob. Defaults to the namespace of the current context.")
+	fs.StringVar(&o.namespace, "n", "", "Shorthand for --namespace.")
+	return fs, o
+}
+
+// parse parses the flags of a command, which may come before or after its
+// positional arguments, and returns the positional arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseName parses the arguments of a command that takes a single MPIJob name.
+func parseName(fs *flag.FlagSet, args []string) (string, error) {
+	positional, err := parse(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(positional) != 1 {
+		return "", fmt.Errorf("%s expects exactly one MPIJob name", fs.Name())
+	}
+	return positional[0], nil
+}
+
+func (o *options) clients() (*clients, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.Context.Namespace = o.namespace
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	mpi, err := versioned.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{mpi: mpi, kube: kube, namespace: namespace}, nil
+}
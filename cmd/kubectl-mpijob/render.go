@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+// renderJob prints the child objects the operator would create for the
+// MPIJob in a file. It works offline, without a cluster.
+func renderJob(args []string) error {
+	fs, o := newFlagSet("render")
+	var filename string
+	var opts render.Options
+	fs.StringVar(&filename, "f", "", "File with the MPIJob to render, - for stdin.")
+	fs.StringVar(&opts.AgentImage, "agent-image", render.DefaultAgentImage,
+		"Image of the init container that copies the rsh agent into the launcher.")
+	fs.StringVar(&opts.ExecProxyURL, "exec-proxy-url", "",
+		"URL of the exec proxy, if the manager runs one.")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if filename == "" {
+		return fmt.Errorf("render expects a file, set with -f")
+	}
+	data, err := readFile(filename)
+	if err != nil {
+		return err
+	}
+	var mpiJob v1.MPIJob
+	if err := yaml.UnmarshalStrict(data, &mpiJob); err != nil {
+		return fmt.Errorf("can't read the MPIJob in %s: %w", filename, err)
+	}
+	if o.namespace != "" {
+		mpiJob.Namespace = o.namespace
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1.AddToScheme(scheme))
+
+	objs, err := render.Objects(&mpiJob, opts)
+	if err != nil {
+		return err
+	}
+	for i, obj := range objs {
+		// set what the controller sets, and the type to make the output applicable
+		if err := controllerutil.SetControllerReference(&mpiJob, obj, scheme); err != nil {
+			return err
+		}
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		if _, err := os.Stdout.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
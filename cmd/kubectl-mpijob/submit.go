@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/batch.test.bdap.com/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// submit creates the MPIJob described by a YAML or JSON file.
+func submit(args []string) error {
+	fs, o := newFlagSet("submit")
+	var filename string
+	fs.StringVar(&filename, "f", "", "File with the MPIJob to submit, - for stdin.")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if filename == "" {
+		return fmt.Errorf("submit expects a file, set with -f")
+	}
+
+	data, err := readFile(filename)
+	if err != nil {
+		return err
+	}
+	var mpiJob v1.MPIJob
+	if err := yaml.UnmarshalStrict(data, &mpiJob); err != nil {
+		return fmt.Errorf("can't read the MPIJob in %s: %w", filename, err)
+	}
+
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	namespace := mpiJob.Namespace
+	if namespace == "" || o.namespace != "" {
+		namespace = c.namespace
+	}
+	created, err := c.mpi.BatchV1().MPIJobs(namespace).Create(context.TODO(), &mpiJob, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("mpijob/%s created in namespace %s\n", created.Name, created.Namespace)
+	return nil
+}
+
+// readFile reads a file, or stdin if filename is -.
+func readFile(filename string) ([]byte, error) {
+	if filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filename)
+}
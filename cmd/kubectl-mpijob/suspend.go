@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setSuspend sets spec.suspend of an MPIJob. The controller then deletes, or
+// creates again, its launcher and workers.
+func setSuspend(command string, args []string, suspend bool) error {
+	fs, o := newFlagSet(command)
+	name, err := parseName(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	patch := []byte(fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspend))
+	if _, err := c.mpi.BatchV1().MPIJobs(c.namespace).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	if suspend {
+		fmt.Printf("mpijob/%s suspended\n", name)
+	} else {
+		fmt.Printf("mpijob/%s resumed\n", name)
+	}
+	return nil
+}
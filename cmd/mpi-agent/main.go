@@ -0,0 +1,371 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// mpi-agent is the rsh agent of the launcher. mpirun runs it as
+// `mpi-agent <pod> <command...>` to start its daemons in the worker pods, and
+// it runs the command in the pod through the exec API, like kubectl exec, with
+// the service account of the launcher. When MPI_EXEC_PROXY_URL is set, it
+// runs the command through the exec proxy of the manager instead, with the
+// exec token of the MPIJob.
+//
+// Run as `mpi-agent --install DIR`, it copies itself to DIR. This is what the
+// init container of the launcher does. Run as `mpi-agent --preflight`, it
+// runs hostname in every host of a hostfile, and prints the results as JSON
+// on the last line of stdout for the controller.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FFFFFaraway/MPI-Operator/execproxy"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// retry is the backoff of the calls to the API server that fail with a
+// transient error, about 30 seconds in total.
+var retry = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    6,
+}
+
+func main() {
+	var install, hostfile, skip string
+	var preflight bool
+	var timeout time.Duration
+	flag.StringVar(&install, "install", "", "Copy the agent to this directory and exit.")
+	flag.BoolVar(&preflight, strings.TrimPrefix(render.PreflightFlag, "--"), false, "Check every host of the hostfile and exit.")
+	flag.StringVar(&hostfile, "hostfile", "", "The hostfile of the preflight check.")
+	flag.StringVar(&skip, "skip", "", "A host the preflight check skips.")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "The timeout of the check of every host.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s POD COMMAND...\n  %s --install DIR\n  %s --preflight --hostfile FILE\n",
+			os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if install != "" {
+		if err := installTo(install); err != nil {
+			fmt.Fprintln(os.Stderr, "mpi-agent:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	run := runWithAPIServer
+	if os.Getenv(render.ExecProxyURLEnv) != "" {
+		run = runWithProxy
+	}
+	if preflight {
+		if hostfile == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		code, err := runPreflight(context.Background(), run, hostfile, skip, timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "mpi-agent:", err)
+		}
+		os.Exit(code)
+	}
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	code, err := run(context.Background(), flag.Arg(0), flag.Args()[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mpi-agent:", err)
+	}
+	os.Exit(code)
+}
+
+// installTo copies the running binary to dir.
+func installTo(dir string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filepath.Join(dir, filepath.Base(self)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0555)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// runFunc runs a command in a pod and returns its exit code.
+type runFunc func(ctx context.Context, podName string, command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
+// runWithProxy runs a command in a pod through the exec proxy and returns its
+// exit code.
+func runWithProxy(ctx context.Context, podName string, command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	proxyURL := os.Getenv(render.ExecProxyURLEnv)
+	if !strings.HasPrefix(proxyURL, "https://") {
+		// the token must not cross the network in clear
+		return 1, fmt.Errorf("the exec proxy URL %q is not an https URL", proxyURL)
+	}
+	httpClient, err := proxyClient()
+	if err != nil {
+		return 1, err
+	}
+	token, err := os.ReadFile(filepath.Join(render.ExecTokenMountPath, render.ExecTokenKey))
+	if err != nil {
+		return 1, err
+	}
+	req := &execproxy.Request{
+		Namespace: os.Getenv(render.JobNamespaceEnv),
+		Job:       os.Getenv(render.JobNameEnv),
+		Pod:       podName,
+		Command:   command,
+	}
+	var code int
+	err = retryTransient(func() error {
+		var err error
+		code, err = execproxy.Exec(ctx, httpClient, proxyURL,
+			strings.TrimSpace(string(token)), req, stdin, stdout, stderr)
+		return err
+	})
+	if err != nil {
+		return 1, err
+	}
+	return code, nil
+}
+
+// proxyClient returns the HTTP client of the exec proxy, which also trusts the
+// CA of the proxy when the manager sets it.
+func proxyClient() (*http.Client, error) {
+	ca := os.Getenv(render.ExecProxyCAEnv)
+	if ca == "" {
+		return http.DefaultClient, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		return nil, fmt.Errorf("no certificate found in %s", render.ExecProxyCAEnv)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+// runWithAPIServer runs a command in a pod and returns its exit code. The
+// command is run by a shell, as mpirun passes it as separate words.
+func runWithAPIServer(ctx context.Context, podName string, command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return 1, err
+	}
+	namespace, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return 1, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return 1, err
+	}
+
+	var pod *corev1.Pod
+	err = retryTransient(func() error {
+		var err error
+		pod, err = client.CoreV1().Pods(string(namespace)).Get(ctx, podName, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return 1, err
+	}
+	i := render.ExecContainer(pod.Annotations, &pod.Spec)
+	if i < 0 {
+		return 1, fmt.Errorf("pod %s has no container to run the command in", podName)
+	}
+	container := pod.Spec.Containers[i].Name
+
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   []string{"/bin/sh", "-c", strings.Join(command, " ")},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return 1, err
+	}
+
+	// The stream is only retried if it failed before any data went through,
+	// as the command may have started otherwise.
+	var started int32
+	streams := remotecommand.StreamOptions{
+		Stdin:  &trackingReader{r: stdin, started: &started},
+		Stdout: &trackingWriter{w: stdout, started: &started},
+		Stderr: &trackingWriter{w: stderr, started: &started},
+	}
+	err = retryTransient(func() error {
+		err := streamContext(ctx, executor, streams)
+		if err != nil && atomic.LoadInt32(&started) != 0 {
+			return permanent{err}
+		}
+		return err
+	})
+	var exitErr exec.CodeExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// streamContext runs the stream of an exec until it ends or the context is
+// done, as the executor doesn't take a context. The stream is abandoned then,
+// and the error of the context is returned, which is not retried.
+func streamContext(ctx context.Context, executor remotecommand.Executor, streams remotecommand.StreamOptions) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(streams)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return permanent{ctx.Err()}
+	}
+}
+
+// permanent wraps an error that must not be retried.
+type permanent struct{ error }
+
+func (p permanent) Unwrap() error { return p.error }
+
+// retryTransient calls f until it succeeds or fails with an error that is not
+// transient.
+func retryTransient(f func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(retry, func() (bool, error) {
+		lastErr = f()
+		if lastErr == nil {
+			return true, nil
+		}
+		if !isTransient(lastErr) {
+			return false, lastErr
+		}
+		fmt.Fprintln(os.Stderr, "mpi-agent: retrying:", lastErr)
+		return false, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return lastErr
+	}
+	var p permanent
+	if errors.As(err, &p) {
+		return p.error
+	}
+	return err
+}
+
+// isTransient reports whether an error may go away by retrying: the API
+// server or the exec proxy is overloaded or unavailable, or the connection to
+// it failed.
+func isTransient(err error) bool {
+	var p permanent
+	var exitErr exec.CodeExitError
+	var started *execproxy.StartedError
+	if errors.As(err, &p) || errors.As(err, &exitErr) || errors.As(err, &started) {
+		return false
+	}
+	var status *execproxy.StatusError
+	if errors.As(err, &status) {
+		return status.Code == http.StatusTooManyRequests || status.Code >= 500
+	}
+	if apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// the upgrade of the exec connection failed, e.g. the kubelet was not
+	// reachable
+	msg := err.Error()
+	return strings.Contains(msg, "error dialing backend") || strings.Contains(msg, "unable to upgrade connection") ||
+		strings.Contains(msg, "connection reset by peer")
+}
+
+// trackingReader records that data has been read.
+type trackingReader struct {
+	r       io.Reader
+	started *int32
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt32(t.started, 1)
+	}
+	return n, err
+}
+
+// trackingWriter records that data has been written.
+type trackingWriter struct {
+	w       io.Writer
+	started *int32
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		atomic.StoreInt32(t.started, 1)
+	}
+	return t.w.Write(p)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+)
+
+// maxMessageLength bounds the error of a host in the results, which end up in
+// the status of the MPIJob.
+const maxMessageLength = 200
+
+// runPreflight runs hostname in every host of the hostfile but skip, at the
+// same time, and checks that it succeeds and prints the name of the host. It
+// prints the results as JSON on the last line of stdout, and returns 0 if all
+// the hosts passed.
+func runPreflight(ctx context.Context, run runFunc, hostfile, skip string, timeout time.Duration) (int, error) {
+	hosts, err := readHostfile(hostfile)
+	if err != nil {
+		return 1, err
+	}
+	results := make([]v1.PreflightHostStatus, 0, len(hosts))
+	for _, host := range hosts {
+		if host != skip {
+			results = append(results, v1.PreflightHostStatus{Name: host})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(result *v1.PreflightHostStatus) {
+			defer wg.Done()
+			checkHost(ctx, run, result, timeout)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	code := 0
+	for _, result := range results {
+		if result.Passed {
+			fmt.Fprintf(os.Stderr, "%s: passed in %dms\n", result.Name, result.LatencyMilliseconds)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: failed: %s\n", result.Name, result.Message)
+			code = 1
+		}
+	}
+	out, err := json.Marshal(results)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Println(string(out))
+	return code, nil
+}
+
+// checkHost runs hostname in a host and records the result. The host fails
+// once the timeout has expired, even if the command doesn't return.
+func checkHost(ctx context.Context, run runFunc, result *v1.PreflightHostStatus, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type outcome struct {
+		code           int
+		err            error
+		stdout, stderr string
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		// the buffers are only read once run has returned
+		var stdout, stderr bytes.Buffer
+		code, err := run(ctx, result.Name, []string{"hostname"}, strings.NewReader(""), &stdout, &stderr)
+		done <- outcome{code: code, err: err, stdout: stdout.String(), stderr: stderr.String()}
+	}()
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+	result.LatencyMilliseconds = time.Since(start).Milliseconds()
+	code, err := out.code, out.err
+	hostname := strings.TrimSpace(out.stdout)
+	switch {
+	case err != nil:
+		result.Message = err.Error()
+	case code != 0:
+		result.Message = fmt.Sprintf("exit code %d: %s", code, strings.TrimSpace(out.stderr))
+	case hostname != result.Name:
+		// mpirun finds the ranks of a host by its hostname
+		result.Message = fmt.Sprintf("the hostname is %q", hostname)
+	default:
+		result.Passed = true
+	}
+	if len(result.Message) > maxMessageLength {
+		result.Message = result.Message[:maxMessageLength]
+	}
+}
+
+// readHostfile returns the hosts of a hostfile, in order.
+func readHostfile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			hosts = append(hosts, fields[0])
+		}
+	}
+	return hosts, scanner.Err()
+}
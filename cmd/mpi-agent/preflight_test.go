@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// fakeHost is how a host of the fake runFunc answers hostname.
+type fakeHost struct {
+	hostname string
+	code     int
+	err      error
+	hang     bool
+}
+
+func fakeRun(hosts map[string]fakeHost) runFunc {
+	return func(ctx context.Context, podName string, command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+		host := hosts[podName]
+		if host.hang {
+			// like an exec whose stream doesn't watch the context
+			select {}
+		}
+		fmt.Fprintln(stdout, host.hostname)
+		if host.code != 0 {
+			fmt.Fprintln(stderr, "hostname: not found")
+		}
+		return host.code, host.err
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	hostfile := filepath.Join(t.TempDir(), "hostfile")
+	content := "# the launcher runs rank 0\njob-launcher slots=1\njob-worker-0 slots=2\n\njob-worker-1 slots=2\n"
+	if err := os.WriteFile(hostfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	healthy := map[string]fakeHost{
+		"job-worker-0": {hostname: "job-worker-0"},
+		"job-worker-1": {hostname: "job-worker-1"},
+	}
+	with := func(name string, host fakeHost) map[string]fakeHost {
+		hosts := map[string]fakeHost{}
+		for k, v := range healthy {
+			hosts[k] = v
+		}
+		hosts[name] = host
+		return hosts
+	}
+	tests := []struct {
+		name        string
+		hosts       map[string]fakeHost
+		skip        string
+		wantCode    int
+		wantHosts   []string
+		wantFailed  string
+		wantMessage string
+	}{
+		{
+			name:      "all passed",
+			hosts:     healthy,
+			skip:      "job-launcher",
+			wantHosts: []string{"job-worker-0", "job-worker-1"},
+		},
+		{
+			name:        "launcher not skipped",
+			hosts:       healthy,
+			wantCode:    1,
+			wantHosts:   []string{"job-launcher", "job-worker-0", "job-worker-1"},
+			wantFailed:  "job-launcher",
+			wantMessage: `the hostname is ""`,
+		},
+		{
+			name:        "exec error",
+			hosts:       with("job-worker-1", fakeHost{err: errors.New("pods is forbidden")}),
+			skip:        "job-launcher",
+			wantCode:    1,
+			wantHosts:   []string{"job-worker-0", "job-worker-1"},
+			wantFailed:  "job-worker-1",
+			wantMessage: "pods is forbidden",
+		},
+		{
+			name:        "exit code",
+			hosts:       with("job-worker-0", fakeHost{code: 127}),
+			skip:        "job-launcher",
+			wantCode:    1,
+			wantHosts:   []string{"job-worker-0", "job-worker-1"},
+			wantFailed:  "job-worker-0",
+			wantMessage: "exit code 127: hostname: not found",
+		},
+		{
+			name:        "wrong hostname",
+			hosts:       with("job-worker-0", fakeHost{hostname: "job-worker-1"}),
+			skip:        "job-launcher",
+			wantCode:    1,
+			wantHosts:   []string{"job-worker-0", "job-worker-1"},
+			wantFailed:  "job-worker-0",
+			wantMessage: `the hostname is "job-worker-1"`,
+		},
+		{
+			name:        "timeout",
+			hosts:       with("job-worker-1", fakeHost{hang: true}),
+			skip:        "job-launcher",
+			wantCode:    1,
+			wantHosts:   []string{"job-worker-0", "job-worker-1"},
+			wantFailed:  "job-worker-1",
+			wantMessage: context.DeadlineExceeded.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code int
+			out := captureOutput(t, func() {
+				var err error
+				code, err = runPreflight(context.Background(), fakeRun(tt.hosts), hostfile, tt.skip, 100*time.Millisecond)
+				if err != nil {
+					t.Error(err)
+				}
+			})
+			if code != tt.wantCode {
+				t.Errorf("got exit code %d, want %d", code, tt.wantCode)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			var results []v1.PreflightHostStatus
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &results); err != nil {
+				t.Fatalf("can't read the results %q: %v", out, err)
+			}
+			if len(results) != len(tt.wantHosts) {
+				t.Fatalf("got results %+v, want hosts %v", results, tt.wantHosts)
+			}
+			for i, result := range results {
+				if result.Name != tt.wantHosts[i] {
+					t.Errorf("got host %s, want %s", result.Name, tt.wantHosts[i])
+				}
+				if result.Passed != (result.Name != tt.wantFailed) {
+					t.Errorf("got %s passed %v", result.Name, result.Passed)
+				}
+				if result.Name == tt.wantFailed && result.Message != tt.wantMessage {
+					t.Errorf("got message %q, want %q", result.Message, tt.wantMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPreflightMissingHostfile(t *testing.T) {
+	code, err := runPreflight(context.Background(), fakeRun(nil), filepath.Join(t.TempDir(), "hostfile"), "", time.Second)
+	if err == nil || code != 1 {
+		t.Errorf("got exit code %d with %v, want 1 with an error", code, err)
+	}
+}
+
+// hangingExecutor is an exec whose stream never ends.
+type hangingExecutor struct{}
+
+func (hangingExecutor) Stream(remotecommand.StreamOptions) error {
+	select {}
+}
+
+func TestStreamContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := streamContext(ctx, hangingExecutor{}, remotecommand.StreamOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) || isTransient(err) {
+		t.Errorf("got %v, want a deadline exceeded error that is not retried", err)
+	}
+}
+
+// captureOutput returns what f prints on stdout, and drops what it prints on
+// stderr.
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, devNull
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	f()
+	w.Close()
+	os.Stdout, os.Stderr = stdout, stderr
+	return <-done
+}
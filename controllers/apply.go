@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"hash/fnv"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// fieldManager owns the fields the controller sets on the child objects.
+	fieldManager = "mpi-operator"
+	// appliedHashAnnotation holds the hash of the last applied object.
+	appliedHashAnnotation = "batch.test.bdap.com/applied-hash"
+)
+
+// reconcileObject creates a child object of the MPIJob, or brings the existing
+// one to the desired state according to the update strategy. With UpdateApply
+// the object is server-side applied, so only the fields set in desired are
+// managed by the controller and the fields set by others are kept. The hash of
+// desired is recorded in an annotation, and nothing is written while it
+// doesn't change.
+//
+// It returns the object in the cluster. An object with the same name that is
+// not controlled by the MPIJob is adopted or rejected with a conflictError,
+// according to the ownership policy.
+func (r *MPIJobReconciler) reconcileObject(ctx context.Context, mpiJob *v1.MPIJob, desired client.Object,
+	update UpdateStrategy, ownership OwnershipPolicy) (client.Object, error) {
+	logger := log.FromContext(ctx)
+	if err := ctrl.SetControllerReference(mpiJob, desired, r.Scheme); err != nil {
+		return nil, err
+	}
+	// server-side apply needs the type of the object
+	gvk, err := apiutil.GVKForObject(desired, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	desired.GetObjectKind().SetGroupVersionKind(gvk)
+	var hash string
+	if update == UpdateApply {
+		if hash, err = objectHash(desired); err != nil {
+			return nil, err
+		}
+		annotations := desired.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[appliedHashAnnotation] = hash
+		desired.SetAnnotations(annotations)
+	}
+
+	obj, err := r.Scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	existing := obj.(client.Object)
+	err = r.Get(ctx, client.ObjectKeyFromObject(desired), existing)
+	if errors.IsNotFound(err) {
+		logger.V(1).Info(gvk.Kind+" doesn't exist, creating...", "Name", desired.GetName())
+		if update == UpdateCreateOnly {
+			err = r.Create(ctx, desired)
+		} else {
+			err = r.Patch(ctx, desired, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return desired, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(existing, mpiJob) {
+		if ownership != OwnershipAdopt || !isOrphan(existing, desired) {
+			return nil, &conflictError{kind: gvk.Kind, name: existing.GetName()}
+		}
+		logger.Info("adopting orphan "+gvk.Kind, "Name", existing.GetName())
+		if update == UpdateCreateOnly {
+			patch := client.MergeFrom(existing.DeepCopyObject().(client.Object))
+			if err := ctrl.SetControllerReference(mpiJob, existing, r.Scheme); err != nil {
+				return nil, err
+			}
+			if err := r.Patch(ctx, existing, patch); err != nil {
+				return nil, err
+			}
+			return existing, nil
+		}
+		// the owner reference in desired is applied with the rest
+		if err := r.Patch(ctx, desired, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+			return nil, err
+		}
+		return desired, nil
+	}
+	if update == UpdateCreateOnly || existing.GetAnnotations()[appliedHashAnnotation] == hash {
+		return existing, nil
+	}
+	logger.V(1).Info(gvk.Kind+" changed, applying...", "Name", desired.GetName())
+	if err := r.Patch(ctx, desired, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return nil, err
+	}
+	return desired, nil
+}
+
+// conflictError is returned when an object with the name of a child object
+// exists but is not controlled by the MPIJob.
+type conflictError struct {
+	kind string
+	name string
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%s %s already exists and is not controlled by this MPIJob resource", e.kind, e.name)
+}
+
+// isOrphan reports whether an existing object can be adopted: it has no
+// controller, isn't being deleted and carries all the labels of the desired
+// object. An object without desired labels is never adopted.
+func isOrphan(existing, desired client.Object) bool {
+	if metav1.GetControllerOf(existing) != nil || existing.GetDeletionTimestamp() != nil || len(desired.GetLabels()) == 0 {
+		return false
+	}
+	for k, v := range desired.GetLabels() {
+		if existing.GetLabels()[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// objectHash returns a hash of the JSON encoding of an object.
+func objectHash(obj client.Object) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// UpdateStrategy is how an existing child object is brought to the desired
+// state.
+type UpdateStrategy string
+
+const (
+	// UpdateApply server-side applies the desired object whenever it changes.
+	UpdateApply UpdateStrategy = "Apply"
+	// UpdateCreateOnly creates the object and leaves it alone afterwards, for
+	// objects like pods whose spec can't be changed.
+	UpdateCreateOnly UpdateStrategy = "CreateOnly"
+)
+
+// OwnershipPolicy is what to do when an object with the name of a child
+// object already exists but is not controlled by the MPIJob. Objects that
+// aren't adopted are a conflict: the MPIJob gets the ResourceConflict
+// condition and isn't reconciled further until the object is removed.
+type OwnershipPolicy string
+
+const (
+	// OwnershipAdopt adopts an object without controller that carries all
+	// the labels of the desired object, e.g. one left behind after its
+	// MPIJob was deleted with the orphan propagation policy.
+	OwnershipAdopt OwnershipPolicy = "Adopt"
+	// OwnershipReject never adopts an object.
+	OwnershipReject OwnershipPolicy = "Reject"
+)
+
+// Child is a kind of object the controller creates for every MPIJob, like the
+// ConfigMap or the launcher Role. Extra children can be registered in
+// MPIJobReconciler.Children to create more objects per MPIJob without
+// changing the controller.
+type Child struct {
+	// Name identifies the child in the logs.
+	Name string
+	// Build returns the desired objects of the child for an MPIJob, without
+	// the OwnerReferences. It may return no object when the MPIJob doesn't
+	// need one.
+	Build func(mpiJob *v1.MPIJob) ([]client.Object, error)
+	// Update defaults to UpdateApply.
+	Update UpdateStrategy
+	// Ownership defaults to OwnershipAdopt.
+	Ownership OwnershipPolicy
+	// Ready reports whether an object in the cluster is ready. The launcher is
+	// only created when the objects of all the children are ready. A nil
+	// Ready means the objects are ready as soon as they exist.
+	Ready func(obj client.Object) bool
+	// Type is an empty object of the kind of the child. When set, the
+	// controller watches the objects of this kind owned by the MPIJobs.
+	Type client.Object
+	// List is an empty list of the kind of the child. When set, the objects
+	// of this kind controlled by the MPIJob that Build doesn't return anymore
+	// are deleted. They are found with the mpi-job-name label.
+	List client.ObjectList
+}
+
+// builtinChildren returns the children created before the workers.
+func (r *MPIJobReconciler) builtinChildren(ctx context.Context) []Child {
+	opts := r.renderOptions()
+	children := []Child{
+		{
+			Name: "ConfigMap",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				return []client.Object{render.ConfigMap(mpiJob)}, nil
+			},
+		},
+		{
+			Name: "LauncherServiceAccount",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				return []client.Object{render.LauncherServiceAccount(mpiJob)}, nil
+			},
+		},
+		{
+			Name: "LauncherRole",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				return []client.Object{render.LauncherRole(mpiJob, opts)}, nil
+			},
+		},
+		{
+			Name: "LauncherRoleBinding",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				return []client.Object{render.LauncherRoleBinding(mpiJob)}, nil
+			},
+		},
+		{
+			Name: "Workspace",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				claim := render.WorkspaceClaim(mpiJob)
+				if claim == nil || workspaceReleased(mpiJob) {
+					return nil, nil
+				}
+				return []client.Object{claim}, nil
+			},
+			// the spec of a claim can't be changed
+			Update: UpdateCreateOnly,
+			// removed when the workspace is released
+			List: &corev1.PersistentVolumeClaimList{},
+		},
+		{
+			Name: "NetworkPolicy",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				if mpiJob.Spec.NetworkIsolation == nil {
+					return nil, nil
+				}
+				opts := opts
+				egress, err := r.launcherEgress(ctx)
+				if err != nil {
+					return nil, err
+				}
+				opts.LauncherEgress = egress
+				var objs []client.Object
+				for _, policy := range render.NetworkPolicies(mpiJob, opts) {
+					objs = append(objs, policy)
+				}
+				return objs, nil
+			},
+			// removed when the network isolation is turned off
+			List: &networkingv1.NetworkPolicyList{},
+		},
+	}
+	if opts.ExecProxyURL != "" {
+		children = append(children, Child{
+			Name: "ExecTokenSecret",
+			Build: func(mpiJob *v1.MPIJob) ([]client.Object, error) {
+				secret, err := render.ExecTokenSecret(mpiJob)
+				if err != nil {
+					return nil, err
+				}
+				return []client.Object{secret}, nil
+			},
+			// the token is only generated once
+			Update:    UpdateCreateOnly,
+			Ownership: OwnershipReject,
+		})
+	}
+	return children
+}
+
+// children returns the built-in children followed by the registered ones.
+func (r *MPIJobReconciler) children(ctx context.Context) []Child {
+	return append(r.builtinChildren(ctx), r.Children...)
+}
+
+// reconcileChildren creates or updates the objects of every child, in order,
+// and reports whether all of them are ready.
+func (r *MPIJobReconciler) reconcileChildren(ctx context.Context, mpiJob *v1.MPIJob) (bool, error) {
+	ready := true
+	for _, child := range r.children(ctx) {
+		childReady, err := r.reconcileChild(ctx, mpiJob, &child)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "can't reconcile child", "Child", child.Name)
+			return false, err
+		}
+		ready = ready && childReady
+	}
+	return ready, nil
+}
+
+func (r *MPIJobReconciler) reconcileChild(ctx context.Context, mpiJob *v1.MPIJob, child *Child) (bool, error) {
+	update := child.Update
+	if update == "" {
+		update = UpdateApply
+	}
+	ownership := child.Ownership
+	if ownership == "" {
+		ownership = OwnershipAdopt
+	}
+	objs, err := child.Build(mpiJob)
+	if err != nil {
+		return false, err
+	}
+	ready := true
+	keep := map[string]bool{}
+	for _, desired := range objs {
+		obj, err := r.reconcileObject(ctx, mpiJob, desired, update, ownership)
+		if err != nil {
+			return false, err
+		}
+		keep[obj.GetName()] = true
+		if child.Ready != nil && !child.Ready(obj) {
+			ready = false
+		}
+	}
+	if child.List != nil {
+		if err := r.pruneChild(ctx, mpiJob, child, keep); err != nil {
+			return false, err
+		}
+	}
+	return ready, nil
+}
+
+// pruneChild deletes the objects of a child controlled by the MPIJob whose
+// name is not in keep.
+func (r *MPIJobReconciler) pruneChild(ctx context.Context, mpiJob *v1.MPIJob, child *Child, keep map[string]bool) error {
+	list := child.List.DeepCopyObject().(client.ObjectList)
+	if err := r.List(ctx, list, client.InNamespace(mpiJob.Namespace),
+		client.MatchingLabels{render.LabelJobName: mpiJob.Name}); err != nil {
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok || keep[obj.GetName()] || !metav1.IsControlledBy(obj, mpiJob) || obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		log.FromContext(ctx).Info("deleting child", "Child", child.Name, "Name", obj.GetName())
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workspaceReleased reports whether the workspace of an MPIJob is not needed
+// anymore: the MPIJob has finished and its workspace is not retained.
+func workspaceReleased(mpiJob *v1.MPIJob) bool {
+	return render.Finished(mpiJob) && mpiJob.Spec.Workspace.RetentionPolicy != v1.WorkspaceRetentionRetain
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failureAction returns the action of the first rule of the failure policy of
+// an MPIJob that matches its failed launcher, FailJob when there is none.
+func failureAction(mpiJob *v1.MPIJob, launcher *corev1.Pod, status *v1.LauncherStatus) v1.FailureAction {
+	policy := mpiJob.Spec.RunPolicy.FailurePolicy
+	if policy == nil {
+		return v1.FailureActionFailJob
+	}
+	for i := range policy.Rules {
+		if ruleMatches(&policy.Rules[i], launcher, status) {
+			return policy.Rules[i].Action
+		}
+	}
+	return v1.FailureActionFailJob
+}
+
+// ruleMatches reports whether all the criteria a rule sets match a failed
+// launcher.
+func ruleMatches(rule *v1.FailurePolicyRule, launcher *corev1.Pod, status *v1.LauncherStatus) bool {
+	if len(rule.OnExitCodes) > 0 {
+		matched := false
+		for _, code := range rule.OnExitCodes {
+			matched = matched || (status.ExitCode != nil && *status.ExitCode == code)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.OnReasons) > 0 {
+		matched := false
+		for _, reason := range rule.OnReasons {
+			matched = matched || (reason != "" && (reason == status.Reason || reason == launcher.Status.Reason))
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.OnPodConditions) > 0 {
+		matched := false
+		for _, conditionType := range rule.OnPodConditions {
+			for _, c := range launcher.Status.Conditions {
+				matched = matched || (c.Type == conditionType && c.Status == corev1.ConditionTrue)
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// retriesExhausted reports whether the Retry action can't restart the MPIJob
+// anymore.
+func retriesExhausted(mpiJob *v1.MPIJob) bool {
+	policy := mpiJob.Spec.RunPolicy.FailurePolicy
+	return policy == nil || mpiJob.Status.Retries >= policy.MaxRetries
+}
+
+// retryLauncher records the failure of the launcher and restarts the MPIJob.
+// Only the Retry action counts the failure against the retry limit.
+func (r *MPIJobReconciler) retryLauncher(ctx context.Context, mpiJob *v1.MPIJob, status *v1.LauncherStatus, action v1.FailureAction) error {
+	if action == v1.FailureActionRetry {
+		mpiJob.Status.Retries++
+	}
+	mpiJob.Status.LastFailure = status
+	return r.restartRun(ctx, mpiJob, "LauncherFailed")
+}
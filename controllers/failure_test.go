@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFailureAction(t *testing.T) {
+	exitCode := func(code int32) *int32 { return &code }
+	policy := &v1.FailurePolicy{Rules: []v1.FailurePolicyRule{
+		{Action: v1.FailureActionIgnore, OnExitCodes: []int32{42}, OnReasons: []string{"Error"}},
+		{Action: v1.FailureActionRetry, OnReasons: []string{"OOMKilled", "Evicted"}},
+		{Action: v1.FailureActionRetry, OnPodConditions: []corev1.PodConditionType{"DisruptionTarget"}},
+		{Action: v1.FailureActionFailJob, OnExitCodes: []int32{1, 2}},
+	}}
+	tests := []struct {
+		name       string
+		policy     *v1.FailurePolicy
+		status     v1.LauncherStatus
+		podReason  string
+		conditions []corev1.PodCondition
+		want       v1.FailureAction
+	}{
+		{
+			name:   "no policy",
+			status: v1.LauncherStatus{ExitCode: exitCode(137), Reason: "OOMKilled"},
+			want:   v1.FailureActionFailJob,
+		},
+		{
+			name:   "all the criteria of a rule",
+			policy: policy,
+			status: v1.LauncherStatus{ExitCode: exitCode(42), Reason: "Error"},
+			want:   v1.FailureActionIgnore,
+		},
+		{
+			name:   "only some criteria of a rule",
+			policy: policy,
+			status: v1.LauncherStatus{ExitCode: exitCode(42), Reason: "Completed"},
+			want:   v1.FailureActionFailJob,
+		},
+		{
+			name:   "container reason",
+			policy: policy,
+			status: v1.LauncherStatus{ExitCode: exitCode(137), Reason: "OOMKilled"},
+			want:   v1.FailureActionRetry,
+		},
+		{
+			name:      "pod reason",
+			policy:    policy,
+			podReason: "Evicted",
+			want:      v1.FailureActionRetry,
+		},
+		{
+			name:       "true pod condition",
+			policy:     policy,
+			conditions: []corev1.PodCondition{{Type: "DisruptionTarget", Status: corev1.ConditionTrue}},
+			want:       v1.FailureActionRetry,
+		},
+		{
+			name:       "false pod condition",
+			policy:     policy,
+			conditions: []corev1.PodCondition{{Type: "DisruptionTarget", Status: corev1.ConditionFalse}},
+			status:     v1.LauncherStatus{ExitCode: exitCode(3)},
+			want:       v1.FailureActionFailJob,
+		},
+		{
+			name:   "exit code",
+			policy: policy,
+			status: v1.LauncherStatus{ExitCode: exitCode(2)},
+			want:   v1.FailureActionFailJob,
+		},
+		{
+			name:   "exit code without a container status",
+			policy: &v1.FailurePolicy{Rules: []v1.FailurePolicyRule{{Action: v1.FailureActionRetry, OnExitCodes: []int32{0}}}},
+			want:   v1.FailureActionFailJob,
+		},
+		{
+			name:   "rule without criteria",
+			policy: &v1.FailurePolicy{Rules: []v1.FailurePolicyRule{{Action: v1.FailureActionRetry}}},
+			want:   v1.FailureActionRetry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{Spec: v1.MPIJobSpec{RunPolicy: v1.RunPolicy{FailurePolicy: tt.policy}}}
+			launcher := &corev1.Pod{Status: corev1.PodStatus{Reason: tt.podReason, Conditions: tt.conditions}}
+			if got := failureAction(mpiJob, launcher, &tt.status); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *v1.FailurePolicy
+		retries int32
+		want    bool
+	}{
+		{name: "no policy", want: true},
+		{name: "retries left", policy: &v1.FailurePolicy{MaxRetries: 3}, retries: 2},
+		{name: "all retries used", policy: &v1.FailurePolicy{MaxRetries: 3}, retries: 3, want: true},
+		{name: "no retries", policy: &v1.FailurePolicy{}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				Spec:   v1.MPIJobSpec{RunPolicy: v1.RunPolicy{FailurePolicy: tt.policy}},
+				Status: v1.MPIJobStatus{Retries: tt.retries},
+			}
+			if got := retriesExhausted(mpiJob); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,12 +18,19 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	batchv1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 )
 
@@ -31,23 +38,36 @@ import (
 type MPIJobReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// KubeClient is used for the APIs the controller-runtime client doesn't
+	// cover, like pods/log.
+	KubeClient kubernetes.Interface
+	// Config is the config of KubeClient, used for the exec API to shut the
+	// launchers down gracefully.
+	Config *rest.Config
+	// LauncherLogTailLines is the number of lines of the log of a failed
+	// launcher recorded in the MPIJob status.
+	LauncherLogTailLines int64
+	// AgentImage is the image that delivers the rsh agent to the launchers.
+	AgentImage string
+	// ExecProxyURL is the URL the launchers reach the exec proxy at. When
+	// empty, the launchers exec into the workers with the API server.
+	ExecProxyURL string
+	// ExecProxyCA is the PEM bundle the launchers verify the certificate of
+	// the exec proxy with, besides the system roots.
+	ExecProxyCA string
+	// ManagerNamespace is the namespace the manager runs in, which the
+	// launchers with network isolation are allowed to reach the exec proxy in.
+	ManagerNamespace string
+	// Children are extra objects created for every MPIJob, after the
+	// built-in ConfigMap and RBAC objects and before the workers.
+	Children []Child
+
+	apiServerEgress apiServerEgress
+	shutdownExecs   shutdownExecs
 }
 
 const (
-	configSuffix            = "-config"
-	configVolumeName        = "mpi-job-config"
-	configMountPath         = "/etc/mpi"
-	kubexecScriptName       = "kubexec.sh"
-	hostfileName            = "hostfile"
-	kubectlDeliveryName     = "kubectl-delivery"
-	kubectlTargetDirEnv     = "TARGET_DIR"
-	kubectlVolumeName       = "mpi-job-kubectl"
-	kubectlMountPath        = "/opt/kube"
-	launcherSuffix          = "-launcher"
-	workerSuffix            = "-worker"
-	initContainerCpu        = "100m"
-	initContainerEphStorage = "5Gi"
-	initContainerMem        = "512Mi"
+	maxLogTailBytes = 4096
 )
 
 //+kubebuilder:rbac:groups=batch.test.bdap.com,resources=mpijobs,verbs=get;list;watch;create;update;patch;delete
@@ -74,52 +94,295 @@ func (r *MPIJobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	logger.Info("Discover one MPIJob")
+	if mpiJob.DeletionTimestamp != nil {
+		return r.finalize(ctx, &mpiJob)
+	}
+	if err := r.reconcileFinalizer(ctx, &mpiJob); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcile(ctx, &mpiJob)
+	var conflict *conflictError
+	if errors.As(err, &conflict) {
+		// wait for the conflicting object to be removed
+		logger.Error(err, "resource conflict")
+		if setResourceConflictCondition(&mpiJob, conflict) {
+			if err := r.Status().Update(ctx, &mpiJob); err != nil {
+				logger.Error(err, "can't update MPIJob status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if err == nil && setResourceConflictCondition(&mpiJob, nil) {
+		if err := r.Status().Update(ctx, &mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return ctrl.Result{}, err
+		}
+	}
+	return result, err
+}
 
-	if mpiJob.Spec.NumWorkers == nil {
+// reconcile creates the child objects of an MPIJob and records their status.
+func (r *MPIJobReconciler) reconcile(ctx context.Context, mpiJob *batchv1.MPIJob) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if len(mpiJob.Spec.WorkerGroups) == 0 && mpiJob.Spec.NumWorkers == nil && !mpiJob.Spec.RunLauncherAsWorker {
 		logger.Error(fmt.Errorf("WorkerTemplate Replicas is null"), "WorkerTemplate Replicas is null")
 		return ctrl.Result{}, nil
 	}
 
-	if err := r.getOrCreateConfigMap(ctx, &mpiJob); err != nil {
-		logger.Error(err, "can't getOrCreateConfigMap")
+	changed := setSuspendedCondition(mpiJob, mpiJob.Spec.Suspend)
+	if mpiJob.Spec.Suspend {
+		changed = resetRun(mpiJob, "Suspended") || changed
+	}
+	if changed {
+		if err := r.Status().Update(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return ctrl.Result{}, err
+		}
+	}
+	if mpiJob.Spec.Suspend {
+		// the launcher checkpoints before its pods are deleted
+		done, err := r.shutDownGracefully(ctx, mpiJob)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !done {
+			return ctrl.Result{RequeueAfter: shutdownPollInterval}, nil
+		}
+		if err := r.suspend(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't suspend")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if err := validateSource(mpiJob.Spec.Source); err != nil {
+		logger.Error(err, "invalid source")
+		return ctrl.Result{}, nil
+	}
+	// the commit is pinned before the pods that check it out are rendered
+	sourceConsistent, err := r.reconcileSource(ctx, mpiJob)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if err := r.getOrCreateLauncherServiceAccount(ctx, &mpiJob); err != nil {
-		logger.Error(err, "can't getOrCreateLauncherServiceAccount")
+
+	childrenReady, err := r.reconcileChildren(ctx, mpiJob)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if err := r.getOrCreateLauncherRole(ctx, &mpiJob); err != nil {
-		logger.Error(err, "can't getOrCreateLauncherRole")
+	if failedOnStartup(mpiJob) {
+		// the workers are gone until the MPIJob is suspended and resumed
+		return ctrl.Result{}, nil
+	}
+	if render.Finished(mpiJob) {
+		// Only the workers are reconciled, to release them with the keep-alive
+		// entrypoint. The launcher isn't created again, e.g. after it was
+		// deleted or evicted, so the outcome of the MPIJob is kept.
+		if _, err := r.reconcileWorkers(ctx, mpiJob); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if mpiJob.Status.StartTime == nil {
+		// before the workers are rendered with the new attempt
+		startRun(mpiJob)
+		if err := r.Status().Update(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return ctrl.Result{}, err
+		}
+	}
+	// The status of an existing launcher is recorded before the workers gate
+	// anything, so a launcher that ends while a worker is down is diagnosed
+	// with it.
+	launcher, err := r.getLauncher(ctx, mpiJob)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if err := r.getOrCreateLauncherRoleBinding(ctx, &mpiJob); err != nil {
-		logger.Error(err, "can't getOrCreateLauncherRoleBinding")
+	if launcher != nil && launcher.DeletionTimestamp == nil {
+		if err := r.updateLauncherStatus(ctx, mpiJob, launcher); err != nil {
+			logger.Error(err, "can't updateLauncherStatus")
+			return ctrl.Result{}, err
+		}
+		if mpiJob.Status.Launcher == nil {
+			// restarted by the failure policy, or the failed launcher of the
+			// restarted run is still in the cache
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+	wait, restarted, err := r.reconcileNodeFailures(ctx, mpiJob)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	worker, err := r.getOrCreateWorker(ctx, &mpiJob)
+	if restarted {
+		return ctrl.Result{}, nil
+	}
+	if wait > 0 {
+		// nothing else is done until the failing workers are replaced
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	// Only the workers and the children gate the launcher. When the launcher
+	// runs as a worker, its own ranks are held by the readiness init container
+	// until it passes the readiness command of the workers.
+	readyWorkers, err := r.reconcileWorkers(ctx, mpiJob)
 	if err != nil {
-		logger.Error(err, "can't getOrCreateWorker")
 		return ctrl.Result{}, err
 	}
+	if !render.WorkersReleased(mpiJob) {
+		if err := r.updateWorkersReadyCondition(ctx, mpiJob, readyWorkers); err != nil {
+			return ctrl.Result{}, err
+		}
+		if readyWorkers != render.NumWorkers(mpiJob) {
+			if workerStartupTimedOut(mpiJob) {
+				return ctrl.Result{}, r.failWorkerStartup(ctx, mpiJob)
+			}
+			logger.Info("workers not ready")
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+	if !childrenReady {
+		logger.Info("children not ready")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if !sourceConsistent {
+		// the ready workers may be the ones just deleted
+		logger.Info("workers being recreated with the pinned commit")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
 
-	ready := worker.Status.ReadyReplicas == *worker.Spec.Replicas
-	if !ready {
-		logger.Info("workers not ready")
+	passed, err := r.reconcilePreflight(ctx, mpiJob)
+	if err != nil {
+		logger.Error(err, "can't reconcilePreflight")
+		return ctrl.Result{}, err
+	}
+	if !passed {
+		if failedOnStartup(mpiJob) {
+			// the workers are gone until the MPIJob is suspended and resumed
+			return ctrl.Result{}, nil
+		}
+		logger.Info("preflight check not passed")
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	_, err = r.getOrCreateLauncher(ctx, &mpiJob)
+	launcher, err = r.getOrCreateLauncher(ctx, mpiJob)
 	if err != nil {
 		logger.Error(err, "can't getOrCreateLauncher")
 		return ctrl.Result{}, err
 	}
+	if launcher.DeletionTimestamp != nil {
+		// the launcher of a restarted run is going away
+		logger.Info("launcher being deleted")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if err := r.updateLauncherStatus(ctx, mpiJob, launcher); err != nil {
+		logger.Error(err, "can't updateLauncherStatus")
+		return ctrl.Result{}, err
+	}
 
 	return ctrl.Result{RequeueAfter: time.Minute}, nil
 }
 
+func (r *MPIJobReconciler) renderOptions() render.Options {
+	return render.Options{
+		AgentImage:   r.AgentImage,
+		ExecProxyURL: r.ExecProxyURL,
+		ExecProxyCA:  r.ExecProxyCA,
+	}
+}
+
+// reconcileWorkers creates the workers of every worker group in the mode
+// requested by the MPIJob, records their status and returns the number of
+// ready workers.
+func (r *MPIJobReconciler) reconcileWorkers(ctx context.Context, mpiJob *batchv1.MPIJob) (int32, error) {
+	logger := log.FromContext(ctx)
+	groups := render.WorkerGroups(mpiJob)
+	if mpiJob.Spec.WorkerMode != batchv1.WorkerModePods {
+		if err := r.deleteWorkerPods(ctx, mpiJob, nil); err != nil {
+			logger.Error(err, "can't deleteWorkerPods")
+			return 0, err
+		}
+		var ready int32
+		pending := false
+		keep := map[string]bool{}
+		for g := range groups {
+			worker, held, err := r.getOrCreateWorker(ctx, mpiJob, &groups[g])
+			if err != nil {
+				logger.Error(err, "can't getOrCreateWorker")
+				return 0, err
+			}
+			keep[worker.Name] = true
+			ready += worker.Status.ReadyReplicas
+			pending = pending || held
+		}
+		if err := r.deleteWorkers(ctx, mpiJob, keep); err != nil {
+			logger.Error(err, "can't deleteWorkers")
+			return 0, err
+		}
+		if pending && mpiJob.Spec.RunPolicy.WorkerUpdatePolicy == batchv1.WorkerUpdateRestart {
+			// the workers are not ready anymore
+			return 0, r.restartRun(ctx, mpiJob, "WorkerUpdate")
+		}
+		if err := r.updateWorkerStatuses(ctx, mpiJob, ready, nil, pending); err != nil {
+			return 0, err
+		}
+		return ready, nil
+	}
+
+	if err := r.deleteWorkers(ctx, mpiJob, nil); err != nil {
+		logger.Error(err, "can't deleteWorkers")
+		return 0, err
+	}
+	pods, err := r.getOrCreateWorkerPods(ctx, mpiJob)
+	if err != nil {
+		logger.Error(err, "can't getOrCreateWorkerPods")
+		return 0, err
+	}
+	statuses := workerStatuses(pods)
+	var ready int32
+	for _, s := range statuses {
+		if s.Ready {
+			ready++
+		}
+	}
+	if err := r.updateWorkerStatuses(ctx, mpiJob, ready, statuses, false); err != nil {
+		return 0, err
+	}
+	return ready, nil
+}
+
+// suspend deletes the launcher and the workers of a suspended MPIJob. The
+// ConfigMap and the RBAC objects are kept for when it is resumed.
+func (r *MPIJobReconciler) suspend(ctx context.Context, mpiJob *batchv1.MPIJob) error {
+	if err := r.deleteLauncher(ctx, mpiJob); err != nil {
+		return err
+	}
+	if err := r.deletePreflight(ctx, mpiJob); err != nil {
+		return err
+	}
+	if err := r.deleteWorkers(ctx, mpiJob, nil); err != nil {
+		return err
+	}
+	if err := r.deleteWorkerPods(ctx, mpiJob, nil); err != nil {
+		return err
+	}
+	return r.updateWorkerStatuses(ctx, mpiJob, 0, nil, false)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *MPIJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podNodeNameField, func(obj client.Object) []string {
+		return []string{obj.(*corev1.Pod).Spec.NodeName}
+	}); err != nil {
+		return err
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&batchv1.MPIJob{}).
-		Complete(r)
+		Owns(&corev1.Pod{}).
+		Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(r.mpiJobsOnNode))
+	for _, child := range r.Children {
+		if child.Type != nil {
+			b = b.Owns(child.Type)
+		}
+	}
+	return b.Complete(r)
 }
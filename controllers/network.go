@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// managerLabels are the labels of the manager pods in config/manager.
+var managerLabels = map[string]string{"control-plane": "controller-manager"}
+
+// apiServerEgressTTL is how long the egress rules to the API server are kept
+// before its endpoints are read again, e.g. after a control plane node was
+// replaced.
+const apiServerEgressTTL = 5 * time.Minute
+
+// apiServerEgress keeps the egress rules to the API server, so its endpoints
+// are not read on every reconcile.
+type apiServerEgress struct {
+	mu      sync.Mutex
+	rules   []networkingv1.NetworkPolicyEgressRule
+	fetched time.Time
+}
+
+// launcherEgress returns the egress rules the launcher needs with network
+// isolation: to the manager pods when the exec proxy is used, and to the
+// endpoints of the API server otherwise, read again after apiServerEgressTTL.
+func (r *MPIJobReconciler) launcherEgress(ctx context.Context) ([]networkingv1.NetworkPolicyEgressRule, error) {
+	if r.ExecProxyURL != "" {
+		namespaces := &metav1.LabelSelector{}
+		if r.ManagerNamespace != "" {
+			namespaces.MatchLabels = map[string]string{"kubernetes.io/metadata.name": r.ManagerNamespace}
+		}
+		return []networkingv1.NetworkPolicyEgressRule{
+			{
+				To: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: namespaces,
+						PodSelector:       &metav1.LabelSelector{MatchLabels: managerLabels},
+					},
+				},
+			},
+		}, nil
+	}
+
+	r.apiServerEgress.mu.Lock()
+	defer r.apiServerEgress.mu.Unlock()
+	if r.apiServerEgress.rules == nil || time.Since(r.apiServerEgress.fetched) >= apiServerEgressTTL {
+		endpoints, err := r.KubeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(ctx, "kubernetes", metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		r.apiServerEgress.rules = apiServerEgressRules(endpoints)
+		r.apiServerEgress.fetched = time.Now()
+	}
+	// the rules end up in objects the client decodes its responses into
+	rules := make([]networkingv1.NetworkPolicyEgressRule, len(r.apiServerEgress.rules))
+	for i := range rules {
+		r.apiServerEgress.rules[i].DeepCopyInto(&rules[i])
+	}
+	return rules, nil
+}
+
+// apiServerEgressRules returns the egress rules to the endpoints of the API
+// server.
+func apiServerEgressRules(endpoints *corev1.Endpoints) []networkingv1.NetworkPolicyEgressRule {
+	// not nil, so a control plane without endpoints is kept too
+	rules := []networkingv1.NetworkPolicyEgressRule{}
+	for _, subset := range endpoints.Subsets {
+		var rule networkingv1.NetworkPolicyEgressRule
+		for _, address := range subset.Addresses {
+			cidr := address.IP + "/32"
+			if ip := net.ParseIP(address.IP); ip != nil && ip.To4() == nil {
+				cidr = address.IP + "/128"
+			}
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		for _, port := range subset.Ports {
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			p := intstr.FromInt(int(port.Port))
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p})
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
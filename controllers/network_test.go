@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLauncherEgressCachesTheAPIServerEndpoints(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+			Ports:     []corev1.EndpointPort{{Port: 6443}},
+		}},
+	})
+	gets := 0
+	kubeClient.PrependReactor("get", "endpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	r := &MPIJobReconciler{KubeClient: kubeClient}
+
+	for i := 0; i < 3; i++ {
+		rules, err := r.launcherEgress(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 || len(rules[0].To) != 2 || len(rules[0].Ports) != 1 {
+			t.Fatalf("got rules %+v", rules)
+		}
+		if cidr := rules[0].To[0].IPBlock.CIDR; cidr != "10.0.0.1/32" {
+			t.Errorf("got CIDR %s, want 10.0.0.1/32", cidr)
+		}
+		if cidr := rules[0].To[1].IPBlock.CIDR; cidr != "fd00::1/128" {
+			t.Errorf("got CIDR %s, want fd00::1/128", cidr)
+		}
+		// the callers own the rules
+		rules[0].To[0].IPBlock.CIDR = "0.0.0.0/0"
+	}
+	if gets != 1 {
+		t.Errorf("got %d reads of the endpoints, want 1", gets)
+	}
+
+	r.apiServerEgress.fetched = time.Now().Add(-apiServerEgressTTL)
+	rules, err := r.launcherEgress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets != 2 {
+		t.Errorf("got %d reads of the endpoints after the TTL, want 2", gets)
+	}
+	if cidr := rules[0].To[0].IPBlock.CIDR; cidr != "10.0.0.1/32" {
+		t.Errorf("got CIDR %s, want 10.0.0.1/32", cidr)
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// podNodeNameField indexes the pods by the node they run on.
+const podNodeNameField = "spec.nodeName"
+
+// podDisruptionTarget is the condition of a pod about to be deleted by a
+// disruption, e.g. a preemption or an eviction.
+const podDisruptionTarget corev1.PodConditionType = "DisruptionTarget"
+
+// nodeReady reports whether the Ready condition of a node is true, and since
+// when it isn't.
+func nodeReady(node *corev1.Node) (bool, time.Time) {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue, c.LastTransitionTime.Time
+		}
+	}
+	return false, node.CreationTimestamp.Time
+}
+
+// workerNodeFailure returns why a worker pod is lost to a failure of its node,
+// and since when, if it is: its node is gone or not ready, or it was evicted
+// by the kubelet, or is about to be disrupted, e.g. preempted.
+func (r *MPIJobReconciler) workerNodeFailure(ctx context.Context, pod *corev1.Pod) (string, time.Time, error) {
+	if pod.Status.Reason == "Evicted" {
+		return pod.Status.Reason, time.Time{}, nil
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == podDisruptionTarget && c.Status == corev1.ConditionTrue {
+			return c.Reason, time.Time{}, nil
+		}
+	}
+	if pod.Spec.NodeName == "" {
+		return "", time.Time{}, nil
+	}
+	var node corev1.Node
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, &node); err != nil {
+		if errors.IsNotFound(err) {
+			return "NodeDeleted", time.Time{}, nil
+		}
+		return "", time.Time{}, err
+	}
+	if ready, since := nodeReady(&node); !ready {
+		return "NodeNotReady", since, nil
+	}
+	return "", time.Time{}, nil
+}
+
+// reconcileNodeFailures replaces the workers of an MPIJob lost to a failure of
+// their node once the toleration is over. Their pods are deleted without a
+// grace period, as their kubelet can't confirm it, so they don't hang in the
+// Terminating state. The launcher is restarted with all the workers, as its
+// MPI world is broken. The workers of a finished MPIJob are left alone. It
+// returns how long to wait for the workers still within the toleration, and
+// whether the MPIJob was restarted.
+func (r *MPIJobReconciler) reconcileNodeFailures(ctx context.Context, mpiJob *v1.MPIJob) (time.Duration, bool, error) {
+	logger := log.FromContext(ctx)
+	toleration := mpiJob.Spec.RunPolicy.NodeFailureTolerationSeconds
+	if toleration == nil || render.Finished(mpiJob) {
+		return 0, false, nil
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(mpiJob.Namespace),
+		client.MatchingLabels{render.LabelJobName: mpiJob.Name, render.LabelJobRole: render.RoleWorker}); err != nil {
+		return 0, false, err
+	}
+	var wait time.Duration
+	var lost []*corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || (owner.UID != mpiJob.UID && owner.Kind != "StatefulSet") {
+			continue
+		}
+		reason, since, err := r.workerNodeFailure(ctx, pod)
+		if err != nil {
+			return 0, false, err
+		}
+		if reason == "" {
+			continue
+		}
+		if remaining := time.Duration(*toleration)*time.Second - time.Since(since); remaining > 0 {
+			logger.Info("WARN: worker node is failing", "Pod Name", pod.Name, "Node", pod.Spec.NodeName, "Reason", reason)
+			if wait == 0 || remaining < wait {
+				wait = remaining
+			}
+			continue
+		}
+		logger.Info("WARN: replacing worker lost to its node", "Pod Name", pod.Name, "Node", pod.Spec.NodeName, "Reason", reason)
+		lost = append(lost, pod)
+	}
+	if len(lost) == 0 {
+		return wait, false, nil
+	}
+	for _, pod := range lost {
+		if err := r.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+			return 0, false, fmt.Errorf("can't delete worker pod %s: %w", pod.Name, err)
+		}
+	}
+	if mpiJob.Status.Launcher == nil {
+		// the launcher will wait for the new workers
+		return 0, false, nil
+	}
+	return 0, true, r.restartRun(ctx, mpiJob, "NodeFailure")
+}
+
+// mpiJobsOnNode maps a node that is not ready to the MPIJobs with pods on it.
+func (r *MPIJobReconciler) mpiJobsOnNode(obj client.Object) []reconcile.Request {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	if ready, _ := nodeReady(node); ready {
+		return nil
+	}
+	var pods corev1.PodList
+	if err := r.List(context.Background(), &pods, client.MatchingFields{podNodeNameField: node.Name},
+		client.HasLabels{render.LabelJobName}); err != nil {
+		return nil
+	}
+	seen := map[types.NamespacedName]bool{}
+	var requests []reconcile.Request
+	for _, pod := range pods.Items {
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Labels[render.LabelJobName]}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		requests = append(requests, reconcile.Request{NamespacedName: name})
+	}
+	return requests
+}
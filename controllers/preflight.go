@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// defaultPreflightBackoffLimit is the number of failed preflight checks of a
+// run after which an MPIJob fails, when not set.
+const defaultPreflightBackoffLimit = 3
+
+// reconcilePreflight runs the preflight pod of an MPIJob, records its results
+// and reports whether all the hosts passed. The pod is deleted once it has
+// ended, so a failed check runs again on a later reconcile, until the backoff
+// limit fails the MPIJob. MPIJobs without preflight check, or whose launcher
+// already exists, pass.
+func (r *MPIJobReconciler) reconcilePreflight(ctx context.Context, mpiJob *v1.MPIJob) (bool, error) {
+	logger := log.FromContext(ctx)
+	if mpiJob.Spec.Preflight == nil || mpiJob.Status.Launcher != nil ||
+		(mpiJob.Status.Preflight != nil && mpiJob.Status.Preflight.Passed) {
+		return true, nil
+	}
+	obj, err := r.reconcileObject(ctx, mpiJob, render.PreflightPod(mpiJob, r.renderOptions()), UpdateCreateOnly, OwnershipReject)
+	if err != nil {
+		return false, err
+	}
+	pod := obj.(*corev1.Pod)
+	if pod.DeletionTimestamp != nil ||
+		(pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed) {
+		return false, nil
+	}
+
+	status := &v1.PreflightStatus{
+		Passed: pod.Status.Phase == corev1.PodSucceeded,
+		Hosts:  r.preflightResults(ctx, pod),
+	}
+	if mpiJob.Status.Preflight != nil {
+		status.Failures = mpiJob.Status.Preflight.Failures
+	}
+	if !status.Passed {
+		status.Failures++
+	}
+	var failed []string
+	for _, host := range status.Hosts {
+		if !host.Passed {
+			failed = append(failed, host.Name)
+		}
+	}
+	condition := metav1.Condition{
+		Type:    v1.ConditionPreflightPassed,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AllHostsPassed",
+		Message: "all the hosts passed the preflight check",
+	}
+	if !status.Passed {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HostsFailed"
+		condition.Message = "the preflight check failed"
+		if len(failed) > 0 {
+			condition.Message = fmt.Sprintf("the preflight check failed on %s", strings.Join(failed, ", "))
+		} else if pod.Status.Reason != "" {
+			// e.g. DeadlineExceeded, when the pod was stopped before it printed
+			// the results
+			condition.Message = fmt.Sprintf("the preflight check failed: %s", pod.Status.Reason)
+		}
+		logger.Info("WARN: preflight check failed", "Hosts", failed)
+	}
+	meta.SetStatusCondition(&mpiJob.Status.Conditions, condition)
+	mpiJob.Status.Preflight = status
+	limit := mpiJob.Spec.Preflight.BackoffLimit
+	if limit == 0 {
+		limit = defaultPreflightBackoffLimit
+	}
+	if !status.Passed && status.Failures >= limit {
+		logger.Info("WARN: preflight backoff limit reached", "Failures", status.Failures)
+		return false, r.failStartup(ctx, mpiJob, preflightFailedReason,
+			fmt.Sprintf("the preflight check failed %d times: %s", status.Failures, condition.Message))
+	}
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		logger.Error(err, "can't update MPIJob status")
+		return false, err
+	}
+	if err := r.deletePreflight(ctx, mpiJob); err != nil {
+		return false, err
+	}
+	return status.Passed, nil
+}
+
+// preflightResults reads the results the preflight pod printed on the last
+// line of its log.
+func (r *MPIJobReconciler) preflightResults(ctx context.Context, pod *corev1.Pod) []v1.PreflightHostStatus {
+	if r.KubeClient == nil || len(pod.Spec.Containers) == 0 {
+		return nil
+	}
+	lines := int64(1)
+	raw, err := r.KubeClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: pod.Spec.Containers[0].Name,
+		TailLines: &lines,
+	}).DoRaw(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "can't get the preflight log")
+		return nil
+	}
+	var hosts []v1.PreflightHostStatus
+	if err := json.Unmarshal(raw, &hosts); err != nil {
+		log.FromContext(ctx).Error(err, "can't read the preflight results")
+		return nil
+	}
+	return hosts
+}
+
+// deletePreflight deletes the preflight pod, if there is one controlled by
+// the MPIJob.
+func (r *MPIJobReconciler) deletePreflight(ctx context.Context, mpiJob *v1.MPIJob) error {
+	var pod corev1.Pod
+	err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: mpiJob.Name + render.PreflightSuffix}, &pod)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(&pod, mpiJob) || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	log.FromContext(ctx).Info("deleting preflight pod", "Pod Name", pod.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, &pod))
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// restartRun restarts the launcher and all the workers of an MPIJob together:
+// their pods are deleted, and the MPIJob starts its next attempt, as when it is
+// resumed. The next attempt is recorded, and the worker StatefulSets are
+// updated with it, before the pods are deleted, so the StatefulSets recreate
+// them from the template of the next attempt, only once. The launcher is
+// created again once the workers are ready.
+func (r *MPIJobReconciler) restartRun(ctx context.Context, mpiJob *v1.MPIJob, reason string) error {
+	logger := log.FromContext(ctx)
+	logger.Info("restarting the launcher and the workers", "Reason", reason)
+	resetRun(mpiJob, reason)
+	meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionSucceeded)
+	meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionFailed)
+	mpiJob.Status.WorkerUpdatePending = false
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		logger.Error(err, "can't update MPIJob status")
+		return err
+	}
+	if mpiJob.Spec.WorkerMode != v1.WorkerModePods {
+		groups := render.WorkerGroups(mpiJob)
+		for g := range groups {
+			if _, err := r.reconcileObject(ctx, mpiJob, render.Worker(mpiJob, &groups[g]), UpdateApply, OwnershipAdopt); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.deleteLauncher(ctx, mpiJob); err != nil {
+		return err
+	}
+	if err := r.deletePreflight(ctx, mpiJob); err != nil {
+		return err
+	}
+	return r.deleteAllWorkerPods(ctx, mpiJob)
+}
+
+// deleteAllWorkerPods deletes the worker pods of an MPIJob, in both worker
+// modes. The StatefulSets and the controller create them again.
+func (r *MPIJobReconciler) deleteAllWorkerPods(ctx context.Context, mpiJob *v1.MPIJob) error {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(mpiJob.Namespace),
+		client.MatchingLabels{render.LabelJobName: mpiJob.Name, render.LabelJobRole: render.RoleWorker}); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || pod.DeletionTimestamp != nil ||
+			(owner.UID != mpiJob.UID && owner.Kind != "StatefulSet") {
+			continue
+		}
+		log.FromContext(ctx).Info("deleting worker pod", "Pod Name", pod.Name)
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
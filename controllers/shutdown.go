@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/util/exec"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// gracefulShutdownFinalizer holds the deletion of an MPIJob with a graceful
+// shutdown until its launcher has checkpointed.
+const gracefulShutdownFinalizer = "batch.test.bdap.com/graceful-shutdown"
+
+// shutdownPollInterval is how often the controller checks whether a launcher
+// has checkpointed.
+const shutdownPollInterval = 5 * time.Second
+
+// reconcileFinalizer adds the graceful shutdown finalizer to an MPIJob that
+// has a graceful shutdown, and removes it from one that has no more.
+func (r *MPIJobReconciler) reconcileFinalizer(ctx context.Context, mpiJob *v1.MPIJob) error {
+	wanted := mpiJob.Spec.RunPolicy.GracefulShutdown != nil
+	if controllerutil.ContainsFinalizer(mpiJob, gracefulShutdownFinalizer) == wanted {
+		return nil
+	}
+	if wanted {
+		controllerutil.AddFinalizer(mpiJob, gracefulShutdownFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(mpiJob, gracefulShutdownFinalizer)
+	}
+	if err := r.Update(ctx, mpiJob); err != nil {
+		log.FromContext(ctx).Error(err, "can't update MPIJob finalizers")
+		return err
+	}
+	return nil
+}
+
+// finalize shuts the launcher of a deleted MPIJob down gracefully, then lets
+// the MPIJob and its children be deleted.
+func (r *MPIJobReconciler) finalize(ctx context.Context, mpiJob *v1.MPIJob) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(mpiJob, gracefulShutdownFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	done, err := r.shutDownGracefully(ctx, mpiJob)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !done {
+		return ctrl.Result{RequeueAfter: shutdownPollInterval}, nil
+	}
+	controllerutil.RemoveFinalizer(mpiJob, gracefulShutdownFinalizer)
+	if err := r.Update(ctx, mpiJob); err != nil {
+		log.FromContext(ctx).Error(err, "can't update MPIJob finalizers")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// shutDownGracefully asks the running launcher of an MPIJob, and its workers
+// if set, to checkpoint, with the signal or the command of the graceful
+// shutdown. It reports whether the pods can be deleted: the launcher has
+// created the marker, or has ended, or the grace period is over. The shutdown
+// time is recorded before the command runs in the background, so the command
+// is sent only once, and the reconcile doesn't wait for it.
+func (r *MPIJobReconciler) shutDownGracefully(ctx context.Context, mpiJob *v1.MPIJob) (bool, error) {
+	logger := log.FromContext(ctx)
+	policy := mpiJob.Spec.RunPolicy.GracefulShutdown
+	if policy == nil {
+		return true, nil
+	}
+	var launcher corev1.Pod
+	err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: mpiJob.Name + render.LauncherSuffix}, &launcher)
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	// only a running launcher has something to checkpoint
+	if err != nil || launcher.DeletionTimestamp != nil || launcher.Status.Phase != corev1.PodRunning ||
+		!metav1.IsControlledBy(&launcher, mpiJob) {
+		return true, nil
+	}
+	i := render.ExecContainer(launcher.Annotations, &launcher.Spec)
+	if i < 0 {
+		logger.Info("WARN: the launcher has no container to shut down")
+		return true, nil
+	}
+	container := launcher.Spec.Containers[i].Name
+
+	gracePeriod := time.Duration(policy.GracePeriodSeconds) * time.Second
+	if mpiJob.Status.ShutdownTime == nil {
+		now := metav1.Now()
+		mpiJob.Status.ShutdownTime = &now
+		if err := r.Status().Update(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return false, err
+		}
+		logger.Info("shutting down the launcher gracefully")
+		// the grace period includes the shutdown command
+		command := shutdownCommand(policy)
+		r.shutdownExecs.run(func() {
+			if _, err := r.execInPod(&launcher, container, command, gracePeriod); err != nil {
+				logger.Error(err, "can't shut down the launcher")
+			}
+		})
+		if policy.Workers {
+			r.shutDownWorkers(ctx, mpiJob, command, gracePeriod)
+		}
+		return false, nil
+	}
+
+	remaining := gracePeriod - time.Since(mpiJob.Status.ShutdownTime.Time)
+	if remaining <= 0 {
+		logger.Info("WARN: the launcher didn't checkpoint within the grace period")
+		return true, nil
+	}
+	if policy.MarkerPath != "" {
+		timeout := shutdownPollInterval
+		if remaining < timeout {
+			timeout = remaining
+		}
+		code, err := r.execInPod(&launcher, container, []string{"test", "-e", policy.MarkerPath}, timeout)
+		if err != nil {
+			logger.Error(err, "can't check the checkpoint marker")
+		} else if code == 0 {
+			logger.Info("the launcher has checkpointed")
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// shutDownWorkers runs the shutdown command in the background in the running
+// workers of an MPIJob, each within the timeout. Errors are only logged, as
+// the launcher matters most.
+func (r *MPIJobReconciler) shutDownWorkers(ctx context.Context, mpiJob *v1.MPIJob, command []string, timeout time.Duration) {
+	logger := log.FromContext(ctx)
+	for _, name := range render.WorkerNames(mpiJob) {
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: name}, pod); err != nil {
+			continue
+		}
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		i := render.ExecContainer(pod.Annotations, &pod.Spec)
+		if i < 0 {
+			logger.Info("WARN: the worker has no container to shut down", "Pod Name", pod.Name)
+			continue
+		}
+		container := pod.Spec.Containers[i].Name
+		r.shutdownExecs.run(func() {
+			if _, err := r.execInPod(pod, container, command, timeout); err != nil {
+				logger.Error(err, "can't shut down the worker", "Pod Name", pod.Name)
+			}
+		})
+	}
+}
+
+// maxShutdownExecs bounds the shutdown commands running at the same time.
+const maxShutdownExecs = 32
+
+// shutdownExecs runs the shutdown commands in the background.
+type shutdownExecs struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+// run calls f in the background, once fewer than maxShutdownExecs calls are
+// running.
+func (s *shutdownExecs) run(f func()) {
+	s.once.Do(func() {
+		s.slots = make(chan struct{}, maxShutdownExecs)
+	})
+	go func() {
+		s.slots <- struct{}{}
+		defer func() { <-s.slots }()
+		f()
+	}()
+}
+
+// shutdownCommand returns the command of a graceful shutdown, or one that
+// sends its signal to the process 1 of the container.
+func shutdownCommand(policy *v1.GracefulShutdown) []string {
+	if len(policy.Command) > 0 {
+		return policy.Command
+	}
+	signal := strings.TrimPrefix(policy.Signal, "SIG")
+	if signal == "" {
+		signal = "TERM"
+	}
+	return []string{"/bin/sh", "-c", "kill -s " + signal + " 1"}
+}
+
+// execInPod runs a command in a container of a pod with the exec API, and
+// returns its exit code. The output of the command is added to the error when
+// it can't be run. The command is given up on after the timeout, and its
+// stream is closed.
+func (r *MPIJobReconciler) execInPod(pod *corev1.Pod, container string, command []string, timeout time.Duration) (int, error) {
+	if r.KubeClient == nil || r.Config == nil {
+		return 0, fmt.Errorf("the exec API is not configured")
+	}
+	req := r.KubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	transport, upgrader, err := spdy.RoundTripperFor(r.Config)
+	if err != nil {
+		return 0, err
+	}
+	conn := &closableUpgrader{Upgrader: upgrader}
+	executor, err := remotecommand.NewSPDYExecutorForTransports(transport, conn, "POST", req.URL())
+	if err != nil {
+		return 0, err
+	}
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(remotecommand.StreamOptions{Stdout: &out, Stderr: &out})
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		// the executor doesn't take a context: closing the connection ends
+		// the stream
+		conn.Close()
+		return 0, fmt.Errorf("%v timed out after %s", command, timeout)
+	}
+	var exitErr exec.CodeExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return 0, nil
+}
+
+// closableUpgrader keeps the connection of an exec stream, to close it.
+type closableUpgrader struct {
+	spdy.Upgrader
+	mu     sync.Mutex
+	conn   httpstream.Connection
+	closed bool
+}
+
+func (u *closableUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		conn.Close()
+		return nil, fmt.Errorf("the exec was given up on")
+	}
+	u.conn = conn
+	return conn, nil
+}
+
+// Close closes the connection, or the one still to be made.
+func (u *closableUpgrader) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	if u.conn != nil {
+		u.conn.Close()
+	}
+}
+
+// syncBuffer is a buffer an exec stream can write to while it is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
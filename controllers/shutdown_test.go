@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownExecsRunAtMostTheLimit(t *testing.T) {
+	var execs shutdownExecs
+	var running, peak int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2*maxShutdownExecs; i++ {
+		wg.Add(1)
+		execs.run(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	// the calls over the limit wait for a slot
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&running); got != maxShutdownExecs {
+		t.Errorf("got %d calls running, want %d", got, maxShutdownExecs)
+	}
+	close(release)
+	wg.Wait()
+	if peak > maxShutdownExecs {
+		t.Errorf("got %d calls running at the same time, want at most %d", peak, maxShutdownExecs)
+	}
+}
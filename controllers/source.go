@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// validateSource checks that the source of an MPIJob has exactly one kind.
+func validateSource(source *v1.Source) error {
+	if source == nil {
+		return nil
+	}
+	kinds := 0
+	for _, set := range []bool{source.Git != nil, source.Archive != nil, source.ConfigMap != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of git, archive and configMap must be set in the source")
+	}
+	return nil
+}
+
+// reconcileSource pins the git source of an MPIJob to a commit in the status,
+// so the pods created later, like the launcher or a restarted worker, check out
+// the same commit as the first ones even if the branch moves. A commit revision
+// is pinned as it is, any other one to the commit the first pod checked out, as
+// reported by its source fetch init container: the controller never reaches
+// the repository itself. A revision that can't be checked out fails the init
+// containers, which the WorkersReady condition reports.
+//
+// The workers started before the pin resolve the revision on their own, so
+// the ones that checked out another commit, e.g. after a push during their
+// startup, are deleted to check out the pinned one. It reports whether all the
+// workers that have fetched the source run the pinned commit. As a worker is
+// only ready once it has fetched the source, the launcher is never created
+// while one doesn't.
+func (r *MPIJobReconciler) reconcileSource(ctx context.Context, mpiJob *v1.MPIJob) (bool, error) {
+	logger := log.FromContext(ctx)
+	source := mpiJob.Spec.Source
+	if source == nil || source.Git == nil {
+		if mpiJob.Status.Source == nil {
+			return true, nil
+		}
+		mpiJob.Status.Source = nil
+		if err := r.Status().Update(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return false, err
+		}
+		return true, nil
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(mpiJob.Namespace),
+		client.MatchingLabels{render.LabelJobName: mpiJob.Name}); err != nil {
+		return false, err
+	}
+	commit := render.SourceCommit(mpiJob)
+	if commit == "" {
+		git := source.Git
+		commit = git.Revision
+		if !render.IsCommit(commit) {
+			commit = ""
+			for i := range pods.Items {
+				if commit = render.FetchedCommit(mpiJob, &pods.Items[i]); commit != "" {
+					break
+				}
+			}
+			if commit == "" {
+				return true, nil
+			}
+		}
+		mpiJob.Status.Source = &v1.SourceStatus{URL: git.URL, Revision: git.Revision, Commit: commit}
+		logger.Info("git source pinned", "Revision", git.Revision, "Commit", commit)
+		if err := r.Status().Update(ctx, mpiJob); err != nil {
+			logger.Error(err, "can't update MPIJob status")
+			return false, err
+		}
+	}
+
+	consistent := true
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Labels[render.LabelJobRole] != render.RoleWorker || pod.DeletionTimestamp != nil {
+			continue
+		}
+		fetched := render.FetchedCommit(mpiJob, pod)
+		if fetched == "" || fetched == commit {
+			continue
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || (owner.UID != mpiJob.UID && owner.Kind != "StatefulSet") {
+			continue
+		}
+		consistent = false
+		logger.Info("WARN: worker checked out another commit, recreating", "Pod Name", pod.Name,
+			"Commit", fetched, "Pinned Commit", commit)
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	return consistent, nil
+}
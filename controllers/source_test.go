@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1.AddToScheme(scheme)
+	return scheme
+}
+
+func TestReconcileSource(t *testing.T) {
+	const (
+		first  = "1111111111111111111111111111111111111111"
+		second = "2222222222222222222222222222222222222222"
+	)
+	tests := []struct {
+		name           string
+		revision       string
+		pinned         string
+		fetched        []string
+		wantCommit     string
+		wantConsistent bool
+		wantDeleted    []bool
+	}{
+		{
+			name:           "nothing fetched yet",
+			revision:       "main",
+			fetched:        []string{"", ""},
+			wantConsistent: true,
+			wantDeleted:    []bool{false, false},
+		},
+		{
+			name:           "pinned to the first fetched commit",
+			revision:       "main",
+			fetched:        []string{first, ""},
+			wantCommit:     first,
+			wantConsistent: true,
+			wantDeleted:    []bool{false, false},
+		},
+		{
+			name:        "a worker fetched after a push",
+			revision:    "main",
+			fetched:     []string{first, second},
+			wantCommit:  first,
+			wantDeleted: []bool{false, true},
+		},
+		{
+			name:        "a worker fetched before the pin",
+			revision:    "main",
+			pinned:      second,
+			fetched:     []string{first, second},
+			wantCommit:  second,
+			wantDeleted: []bool{true, false},
+		},
+		{
+			name:           "commit revision",
+			revision:       first,
+			fetched:        []string{"", first},
+			wantCommit:     first,
+			wantConsistent: true,
+			wantDeleted:    []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns", UID: "job-uid"},
+				Spec: v1.MPIJobSpec{
+					NumWorkers: int32Ptr(len(tt.fetched)),
+					WorkerMode: v1.WorkerModePods,
+					Source:     &v1.Source{Git: &v1.GitSource{URL: "https://git/repo", Revision: tt.revision}},
+				},
+			}
+			if tt.pinned != "" {
+				mpiJob.Status.Source = &v1.SourceStatus{URL: "https://git/repo", Revision: tt.revision, Commit: tt.pinned}
+			}
+			scheme := testScheme()
+			objs := []client.Object{mpiJob}
+			group := render.WorkerGroups(mpiJob)[0]
+			for i, commit := range tt.fetched {
+				pod := render.WorkerPod(mpiJob, &group, i)
+				controller := true
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "MPIJob", Name: "job", UID: mpiJob.UID, Controller: &controller}}
+				if commit != "" {
+					pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+						Name: "mpi-source-fetch",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							Message: commit + "\n",
+						}},
+					}}
+				}
+				objs = append(objs, pod)
+			}
+			r := &MPIJobReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				Scheme: scheme,
+			}
+
+			consistent, err := r.reconcileSource(context.Background(), mpiJob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if consistent != tt.wantConsistent {
+				t.Errorf("got consistent %v, want %v", consistent, tt.wantConsistent)
+			}
+			if commit := render.SourceCommit(mpiJob); commit != tt.wantCommit {
+				t.Errorf("got pinned commit %q, want %q", commit, tt.wantCommit)
+			}
+			for i, want := range tt.wantDeleted {
+				var pod corev1.Pod
+				err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns", Name: render.WorkerName(mpiJob, &group, i)}, &pod)
+				if deleted := errors.IsNotFound(err); deleted != want {
+					t.Errorf("got worker %d deleted %v, want %v", i, deleted, want)
+				}
+			}
+		})
+	}
+}
+
+func int32Ptr(i int) *int32 {
+	v := int32(i)
+	return &v
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The reasons of the Failed condition of an MPIJob that couldn't start: its
+// workers weren't ready in time, or failed the preflight check too many times.
+const (
+	workerStartupTimeoutReason = "WorkerStartupTimeout"
+	preflightFailedReason      = "PreflightFailed"
+)
+
+// maxConditionMessageBytes keeps the conditions small, whatever the number of
+// workers.
+const maxConditionMessageBytes = 1024
+
+// waitingReasons are the reasons of a waiting container that keep a pod from
+// starting until something is fixed.
+var waitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// podStartupProblem returns why a pod can't start, if it can't: it is
+// unschedulable, or one of its containers can't run.
+func podStartupProblem(pod *corev1.Pod) (string, string) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return c.Reason, c.Message
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if w := cs.State.Waiting; w != nil && waitingReasons[w.Reason] {
+			return w.Reason, fmt.Sprintf("container %s: %s", cs.Name, w.Message)
+		}
+	}
+	return "", ""
+}
+
+// updateWorkersReadyCondition sets the WorkersReady condition from the worker
+// pods, with the reasons why they can't start.
+func (r *MPIJobReconciler) updateWorkersReadyCondition(ctx context.Context, mpiJob *v1.MPIJob, readyWorkers int32) error {
+	numWorkers := render.NumWorkers(mpiJob)
+	condition := metav1.Condition{
+		Type:    v1.ConditionWorkersReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AllWorkersReady",
+		Message: fmt.Sprintf("%d/%d workers are ready", readyWorkers, numWorkers),
+	}
+	if readyWorkers != numWorkers {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "WorkersStarting"
+		var problems []string
+		for _, name := range render.WorkerNames(mpiJob) {
+			var pod corev1.Pod
+			err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: name}, &pod)
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			if err != nil {
+				continue
+			}
+			reason, message := podStartupProblem(&pod)
+			if reason == "" {
+				continue
+			}
+			if len(problems) == 0 {
+				condition.Reason = reason
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s: %s", name, reason, message))
+		}
+		if len(problems) > 0 {
+			condition.Message += "; " + strings.Join(problems, "; ")
+		}
+		if len(condition.Message) > maxConditionMessageBytes {
+			condition.Message = condition.Message[:maxConditionMessageBytes]
+		}
+	}
+	if c := meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionWorkersReady); c != nil &&
+		c.Status == condition.Status && c.Reason == condition.Reason && c.Message == condition.Message {
+		return nil
+	}
+	meta.SetStatusCondition(&mpiJob.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		log.FromContext(ctx).Error(err, "can't update MPIJob status")
+		return err
+	}
+	return nil
+}
+
+// workerStartupTimedOut reports whether the workers of an MPIJob have not
+// been ready for longer than the startup timeout since they were started. The
+// timeout doesn't apply anymore once the launcher has been created.
+func workerStartupTimedOut(mpiJob *v1.MPIJob) bool {
+	timeout := mpiJob.Spec.RunPolicy.WorkerStartupTimeoutSeconds
+	if timeout == nil || mpiJob.Status.StartTime == nil || mpiJob.Status.Launcher != nil {
+		return false
+	}
+	return time.Since(mpiJob.Status.StartTime.Time) > time.Duration(*timeout)*time.Second
+}
+
+// failedOnStartup reports whether the workers of an MPIJob timed out or
+// failed the preflight check too many times.
+func failedOnStartup(mpiJob *v1.MPIJob) bool {
+	c := meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionFailed)
+	return c != nil && c.Status == metav1.ConditionTrue &&
+		(c.Reason == workerStartupTimeoutReason || c.Reason == preflightFailedReason)
+}
+
+// failWorkerStartup fails an MPIJob whose workers timed out.
+func (r *MPIJobReconciler) failWorkerStartup(ctx context.Context, mpiJob *v1.MPIJob) error {
+	message := fmt.Sprintf("the workers were not ready after %ds", *mpiJob.Spec.RunPolicy.WorkerStartupTimeoutSeconds)
+	if c := meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionWorkersReady); c != nil {
+		message += ": " + c.Message
+	}
+	log.FromContext(ctx).Info("WARN: worker startup timed out")
+	return r.failStartup(ctx, mpiJob, workerStartupTimeoutReason, message)
+}
+
+// failStartup fails an MPIJob that couldn't start for the reason, and deletes
+// the preflight pod and the workers so they don't hold resources.
+func (r *MPIJobReconciler) failStartup(ctx context.Context, mpiJob *v1.MPIJob, reason, message string) error {
+	logger := log.FromContext(ctx)
+	meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionSucceeded)
+	meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+		Type:    v1.ConditionFailed,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		logger.Error(err, "can't update MPIJob status")
+		return err
+	}
+	if err := r.deletePreflight(ctx, mpiJob); err != nil {
+		return err
+	}
+	if err := r.deleteWorkers(ctx, mpiJob, nil); err != nil {
+		return err
+	}
+	return r.deleteWorkerPods(ctx, mpiJob, nil)
+}
+
+// resetRun forgets the current run of an MPIJob: its start time, its launcher,
+// its preflight checks and a startup failure, so it starts anew as the next
+// attempt, for the given reason. The attempt is counted here, before any pod
+// of the next attempt is created. It reports whether the status has changed.
+func resetRun(mpiJob *v1.MPIJob, reason string) bool {
+	changed := mpiJob.Status.StartTime != nil || mpiJob.Status.Launcher != nil ||
+		mpiJob.Status.Preflight != nil || failedOnStartup(mpiJob)
+	// a run that never started isn't restarted
+	if mpiJob.Status.StartTime != nil {
+		if mpiJob.Status.Attempt < 1 {
+			mpiJob.Status.Attempt = 1
+		}
+		mpiJob.Status.Attempt++
+		mpiJob.Status.RestartReason = reason
+	}
+	mpiJob.Status.StartTime = nil
+	mpiJob.Status.Launcher = nil
+	mpiJob.Status.Preflight = nil
+	if failedOnStartup(mpiJob) {
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionFailed)
+	}
+	return changed
+}
+
+// startRun starts the current attempt of an MPIJob, the first one if none was
+// counted yet.
+func startRun(mpiJob *v1.MPIJob) {
+	now := metav1.Now()
+	mpiJob.Status.StartTime = &now
+	if mpiJob.Status.Attempt < 1 {
+		mpiJob.Status.Attempt = 1
+	}
+	mpiJob.Status.ShutdownTime = nil
+}
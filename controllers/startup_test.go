@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStartupProblem(t *testing.T) {
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "oops"}}
+	}
+	tests := []struct {
+		name        string
+		status      corev1.PodStatus
+		wantReason  string
+		wantMessage string
+	}{
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Reason:  corev1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available",
+			}}},
+			wantReason:  corev1.PodReasonUnschedulable,
+			wantMessage: "0/3 nodes are available",
+		},
+		{
+			name: "scheduled",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodScheduled,
+				Status: corev1.ConditionTrue,
+			}}},
+		},
+		{
+			name: "init container image",
+			status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "fetch", State: waiting("ImagePullBackOff")},
+			}},
+			wantReason:  "ImagePullBackOff",
+			wantMessage: "container fetch: oops",
+		},
+		{
+			name: "crash loop",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "worker", State: waiting("CrashLoopBackOff")},
+			}},
+			wantReason:  "CrashLoopBackOff",
+			wantMessage: "container worker: oops",
+		},
+		{
+			name: "creating",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "worker", State: waiting("ContainerCreating")},
+			}},
+		},
+		{
+			name: "running",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "worker", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, message := podStartupProblem(&corev1.Pod{Status: tt.status})
+			if reason != tt.wantReason || message != tt.wantMessage {
+				t.Errorf("got %q, %q, want %q, %q", reason, message, tt.wantReason, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestResetRun(t *testing.T) {
+	started := metav1.NewTime(time.Now())
+	failed := func(reason string) []metav1.Condition {
+		return []metav1.Condition{{Type: v1.ConditionFailed, Status: metav1.ConditionTrue, Reason: reason}}
+	}
+	tests := []struct {
+		name        string
+		status      v1.MPIJobStatus
+		wantChanged bool
+		wantAttempt int32
+		wantFailed  bool
+	}{
+		{
+			name: "never started",
+		},
+		{
+			name:        "first attempt",
+			status:      v1.MPIJobStatus{StartTime: &started, Attempt: 1},
+			wantChanged: true,
+			wantAttempt: 2,
+		},
+		{
+			name:        "failed preflight checks",
+			status:      v1.MPIJobStatus{Preflight: &v1.PreflightStatus{Failures: 2}},
+			wantChanged: true,
+		},
+		{
+			name: "preflight failed",
+			status: v1.MPIJobStatus{StartTime: &started, Attempt: 1, Conditions: failed(preflightFailedReason),
+				Preflight: &v1.PreflightStatus{Failures: 3}},
+			wantChanged: true,
+			wantAttempt: 2,
+		},
+		{
+			name:        "workers timed out",
+			status:      v1.MPIJobStatus{StartTime: &started, Attempt: 3, Conditions: failed(workerStartupTimeoutReason)},
+			wantChanged: true,
+			wantAttempt: 4,
+		},
+		{
+			name:       "launcher failed",
+			status:     v1.MPIJobStatus{Conditions: failed("Error")},
+			wantFailed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{Status: tt.status}
+			if changed := resetRun(mpiJob, "Resumed"); changed != tt.wantChanged {
+				t.Errorf("got changed %v, want %v", changed, tt.wantChanged)
+			}
+			if mpiJob.Status.Attempt != tt.wantAttempt {
+				t.Errorf("got attempt %d, want %d", mpiJob.Status.Attempt, tt.wantAttempt)
+			}
+			if mpiJob.Status.StartTime != nil || mpiJob.Status.Preflight != nil {
+				t.Errorf("the run wasn't forgotten: %+v", mpiJob.Status)
+			}
+			if got := len(mpiJob.Status.Conditions) > 0; got != tt.wantFailed {
+				t.Errorf("got conditions %v, want failed %v", mpiJob.Status.Conditions, tt.wantFailed)
+			}
+		})
+	}
+}
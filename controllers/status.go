@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+func (r *MPIJobReconciler) updateWorkerStatuses(ctx context.Context, mpiJob *v1.MPIJob, readyWorkers int32, statuses []v1.WorkerStatus,
+	updatePending bool) error {
+	selector := render.Selector(mpiJob).String()
+	if mpiJob.Status.ReadyWorkers == readyWorkers && equality.Semantic.DeepEqual(mpiJob.Status.Workers, statuses) &&
+		mpiJob.Status.Selector == selector && mpiJob.Status.WorkerUpdatePending == updatePending {
+		return nil
+	}
+	mpiJob.Status.ReadyWorkers = readyWorkers
+	mpiJob.Status.Workers = statuses
+	mpiJob.Status.WorkerUpdatePending = updatePending
+	mpiJob.Status.Selector = selector
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		log.FromContext(ctx).Error(err, "can't update MPIJob status")
+		return err
+	}
+	return nil
+}
+
+// launcherContainerStatus returns the status of the first container of the
+// launcher, the one running mpirun.
+func launcherContainerStatus(launcher *corev1.Pod) *corev1.ContainerStatus {
+	if len(launcher.Spec.Containers) == 0 {
+		return nil
+	}
+	for i := range launcher.Status.ContainerStatuses {
+		if launcher.Status.ContainerStatuses[i].Name == launcher.Spec.Containers[0].Name {
+			return &launcher.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// updateLauncherStatus records the phase of the launcher in the MPIJob status.
+// When the launcher has ended it also records how it terminated, and when it
+// has failed the tail of its log and the workers that weren't healthy. A
+// failed launcher fails the MPIJob, unless the failure policy restarts it.
+func (r *MPIJobReconciler) updateLauncherStatus(ctx context.Context, mpiJob *v1.MPIJob, launcher *corev1.Pod) error {
+	phase := launcher.Status.Phase
+	// the failed launcher of a restarted run may still be in the cache, and
+	// its failure must not be counted again
+	if last := mpiJob.Status.LastFailure; last != nil && last.UID != "" && last.UID == launcher.UID {
+		return nil
+	}
+	// the diagnostics of an ended launcher are only captured once
+	if current := mpiJob.Status.Launcher; current != nil && current.Phase == phase &&
+		(current.UID == "" || current.UID == launcher.UID) {
+		return nil
+	}
+	status := &v1.LauncherStatus{Phase: phase, UID: launcher.UID}
+	if phase == corev1.PodSucceeded || phase == corev1.PodFailed {
+		if cs := launcherContainerStatus(launcher); cs != nil && cs.State.Terminated != nil {
+			exitCode := cs.State.Terminated.ExitCode
+			status.ExitCode = &exitCode
+			status.Reason = cs.State.Terminated.Reason
+			status.Message = cs.State.Terminated.Message
+		}
+	}
+
+	switch phase {
+	case corev1.PodSucceeded:
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionFailed)
+		meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+			Type:    v1.ConditionSucceeded,
+			Status:  metav1.ConditionTrue,
+			Reason:  "LauncherSucceeded",
+			Message: "launcher pod has succeeded",
+		})
+	case corev1.PodFailed:
+		status.LogTail = r.launcherLogTail(ctx, launcher)
+		unhealthy, err := r.unhealthyWorkers(ctx, mpiJob)
+		if err != nil {
+			return err
+		}
+		status.UnhealthyWorkers = unhealthy
+		message := launcherFailedMessage(status)
+		action := failureAction(mpiJob, launcher, status)
+		if action == v1.FailureActionIgnore || (action == v1.FailureActionRetry && !retriesExhausted(mpiJob)) {
+			log.FromContext(ctx).Info("WARN: launcher failed, restarting", "Action", action, "Message", message)
+			return r.retryLauncher(ctx, mpiJob, status, action)
+		}
+		if action == v1.FailureActionRetry {
+			message += fmt.Sprintf("; the retry limit of %d is reached", mpiJob.Spec.RunPolicy.FailurePolicy.MaxRetries)
+		}
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionSucceeded)
+		meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+			Type:    v1.ConditionFailed,
+			Status:  metav1.ConditionTrue,
+			Reason:  "LauncherFailed",
+			Message: message,
+		})
+	default:
+		// a new launcher is running, e.g. after the old one was deleted
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionSucceeded)
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionFailed)
+	}
+
+	mpiJob.Status.Launcher = status
+	if err := r.Status().Update(ctx, mpiJob); err != nil {
+		log.FromContext(ctx).Error(err, "can't update MPIJob status")
+		return err
+	}
+	return nil
+}
+
+func launcherFailedMessage(status *v1.LauncherStatus) string {
+	msg := "launcher pod has failed"
+	if status.ExitCode != nil {
+		msg = fmt.Sprintf("launcher exited with code %d", *status.ExitCode)
+	}
+	if status.Reason != "" {
+		msg += fmt.Sprintf(" (%s)", status.Reason)
+	}
+	if status.Message != "" {
+		msg += ": " + strings.TrimSpace(status.Message)
+	}
+	if len(status.UnhealthyWorkers) > 0 {
+		var names []string
+		for _, w := range status.UnhealthyWorkers {
+			names = append(names, w.Name)
+		}
+		msg += fmt.Sprintf("; unhealthy workers: %s", strings.Join(names, ", "))
+	}
+	return msg
+}
+
+// launcherLogTail returns the last lines of the log of the launcher container.
+// Errors are only logged, as the log is not essential to the status.
+func (r *MPIJobReconciler) launcherLogTail(ctx context.Context, launcher *corev1.Pod) string {
+	if r.KubeClient == nil || r.LauncherLogTailLines <= 0 || len(launcher.Spec.Containers) == 0 {
+		return ""
+	}
+	lines := r.LauncherLogTailLines
+	raw, err := r.KubeClient.CoreV1().Pods(launcher.Namespace).GetLogs(launcher.Name, &corev1.PodLogOptions{
+		Container: launcher.Spec.Containers[0].Name,
+		TailLines: &lines,
+	}).DoRaw(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "can't get the launcher log")
+		return ""
+	}
+	// keep the status object small, whatever the length of the lines
+	if len(raw) > maxLogTailBytes {
+		raw = raw[len(raw)-maxLogTailBytes:]
+	}
+	return string(raw)
+}
+
+// unhealthyWorkers returns the workers that are not ready or have restarted.
+func (r *MPIJobReconciler) unhealthyWorkers(ctx context.Context, mpiJob *v1.MPIJob) ([]v1.WorkerStatus, error) {
+	var unhealthy []v1.WorkerStatus
+	for _, name := range render.WorkerNames(mpiJob) {
+		var pod corev1.Pod
+		err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: name}, &pod)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		status := workerStatus(&pod)
+		if err != nil {
+			// the worker pod is gone
+			status = v1.WorkerStatus{Name: name}
+		}
+		if !status.Ready || status.Restarts > 0 {
+			unhealthy = append(unhealthy, status)
+		}
+	}
+	return unhealthy, nil
+}
+
+// setSuspendedCondition sets or removes the Suspended condition, and reports
+// whether it has changed.
+func setSuspendedCondition(mpiJob *v1.MPIJob, suspended bool) bool {
+	if !suspended {
+		if meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionSuspended) == nil {
+			return false
+		}
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionSuspended)
+		return true
+	}
+	if meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionSuspended) {
+		return false
+	}
+	meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+		Type:    v1.ConditionSuspended,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Suspended",
+		Message: "MPIJob is suspended",
+	})
+	return true
+}
+
+// setResourceConflictCondition sets the ResourceConflict condition for a
+// conflicting object, or removes it when conflict is nil, and reports whether
+// it has changed.
+func setResourceConflictCondition(mpiJob *v1.MPIJob, conflict *conflictError) bool {
+	if conflict == nil {
+		if meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionResourceConflict) == nil {
+			return false
+		}
+		meta.RemoveStatusCondition(&mpiJob.Status.Conditions, v1.ConditionResourceConflict)
+		return true
+	}
+	message := conflict.Error()
+	if c := meta.FindStatusCondition(mpiJob.Status.Conditions, v1.ConditionResourceConflict); c != nil && c.Message == message {
+		return false
+	}
+	meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+		Type:    v1.ConditionResourceConflict,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ResourceConflict",
+		Message: message,
+	})
+	return true
+}
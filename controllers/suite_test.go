@@ -17,20 +17,32 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"errors"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	batchv1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -80,3 +92,277 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// newTestReconciler returns a reconciler talking to the test environment.
+func newTestReconciler() *MPIJobReconciler {
+	return &MPIJobReconciler{
+		Client:     k8sClient,
+		Scheme:     scheme.Scheme,
+		KubeClient: kubernetes.NewForConfigOrDie(cfg),
+		Config:     cfg,
+		AgentImage: "mpi-agent",
+	}
+}
+
+// createTestMPIJob creates an MPIJob with one worker Pod, which the workers
+// of the test environment never run since there is no kubelet.
+func createTestMPIJob(ctx context.Context, name string) *batchv1.MPIJob {
+	container := corev1.Container{Name: "main", Image: "mpi", Command: []string{"mpirun", "hostname"}}
+	mpiJob := &batchv1.MPIJob{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: batchv1.MPIJobSpec{
+			LauncherTemplate: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{container}},
+			},
+			WorkerTemplate: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{container}},
+			},
+			NumWorkers:          int32Ptr(1),
+			WorkerMode:          batchv1.WorkerModePods,
+			WorkerCommandPolicy: batchv1.WorkerCommandReplace,
+		},
+	}
+	Expect(k8sClient.Create(ctx, mpiJob)).To(Succeed())
+	return mpiJob
+}
+
+// reconcileTestMPIJob runs one reconcile of the MPIJob and reads it back.
+func reconcileTestMPIJob(ctx context.Context, r *MPIJobReconciler, mpiJob *batchv1.MPIJob) ctrl.Result {
+	key := types.NamespacedName{Namespace: mpiJob.Namespace, Name: mpiJob.Name}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(k8sClient.Get(ctx, key, mpiJob)).To(Succeed())
+	return result
+}
+
+// createRunningLauncher creates a launcher Pod controlled by the MPIJob and
+// marks it running, as the test environment has no kubelet.
+func createRunningLauncher(ctx context.Context, mpiJob *batchv1.MPIJob) *corev1.Pod {
+	launcher := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: mpiJob.Name + render.LauncherSuffix, Namespace: mpiJob.Namespace},
+		Spec:       *mpiJob.Spec.LauncherTemplate.Spec.DeepCopy(),
+	}
+	Expect(controllerutil.SetControllerReference(mpiJob, launcher, scheme.Scheme)).To(Succeed())
+	Expect(k8sClient.Create(ctx, launcher)).To(Succeed())
+	launcher.Status.Phase = corev1.PodRunning
+	Expect(k8sClient.Status().Update(ctx, launcher)).To(Succeed())
+	return launcher
+}
+
+// testConfigMap returns a child ConfigMap of the MPIJob to reconcile.
+func testConfigMap(mpiJob *batchv1.MPIJob, data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mpiJob.Name + "-child",
+			Namespace: mpiJob.Namespace,
+			Labels:    render.Labels(mpiJob, ""),
+		},
+		Data: map[string]string{"data": data},
+	}
+}
+
+// failLauncher marks the launcher failed with an exit code.
+func failLauncher(ctx context.Context, launcher *corev1.Pod, exitCode int32) {
+	launcher.Status.Phase = corev1.PodFailed
+	launcher.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  launcher.Spec.Containers[0].Name,
+		Image: launcher.Spec.Containers[0].Image,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: "Error"}},
+	}}
+	Expect(k8sClient.Status().Update(ctx, launcher)).To(Succeed())
+}
+
+var _ = Describe("reconcileObject", func() {
+	ctx := context.Background()
+
+	It("only applies an object when its desired state changes", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "apply")
+		_, err := r.reconcileObject(ctx, mpiJob, testConfigMap(mpiJob, "a"), UpdateApply, OwnershipReject)
+		Expect(err).NotTo(HaveOccurred())
+		var configMap corev1.ConfigMap
+		key := types.NamespacedName{Namespace: mpiJob.Namespace, Name: mpiJob.Name + "-child"}
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		version := configMap.ResourceVersion
+
+		_, err = r.reconcileObject(ctx, mpiJob, testConfigMap(mpiJob, "a"), UpdateApply, OwnershipReject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.ResourceVersion).To(Equal(version))
+
+		_, err = r.reconcileObject(ctx, mpiJob, testConfigMap(mpiJob, "b"), UpdateApply, OwnershipReject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.ResourceVersion).NotTo(Equal(version))
+		Expect(configMap.Data["data"]).To(Equal("b"))
+	})
+
+	It("adopts an orphan object with the labels of the child", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "adopt")
+		Expect(k8sClient.Create(ctx, testConfigMap(mpiJob, "orphan"))).To(Succeed())
+		for _, update := range []UpdateStrategy{UpdateCreateOnly, UpdateApply} {
+			_, err := r.reconcileObject(ctx, mpiJob, testConfigMap(mpiJob, "a"), update, OwnershipAdopt)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		var configMap corev1.ConfigMap
+		key := types.NamespacedName{Namespace: mpiJob.Namespace, Name: mpiJob.Name + "-child"}
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(metav1.IsControlledBy(&configMap, mpiJob)).To(BeTrue())
+		Expect(configMap.Data["data"]).To(Equal("a"))
+	})
+
+	It("reports a conflict for an object it can't adopt", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "conflict")
+		configMap := testConfigMap(mpiJob, "other")
+		configMap.Labels = nil
+		Expect(k8sClient.Create(ctx, configMap)).To(Succeed())
+		for _, ownership := range []OwnershipPolicy{OwnershipReject, OwnershipAdopt} {
+			_, err := r.reconcileObject(ctx, mpiJob, testConfigMap(mpiJob, "a"), UpdateApply, ownership)
+			var conflict *conflictError
+			Expect(errors.As(err, &conflict)).To(BeTrue())
+		}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), configMap)).To(Succeed())
+		Expect(metav1.GetControllerOf(configMap)).To(BeNil())
+		Expect(configMap.Data["data"]).To(Equal("other"))
+	})
+})
+
+var _ = Describe("MPIJob reconcile", func() {
+	ctx := context.Background()
+
+	It("leaves the launcher of a finished MPIJob alone", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "finished")
+		reconcileTestMPIJob(ctx, r, mpiJob)
+
+		// the launcher was deleted after the MPIJob succeeded
+		now := metav1.Now()
+		mpiJob.Status.StartTime = &now
+		meta.SetStatusCondition(&mpiJob.Status.Conditions, metav1.Condition{
+			Type:   batchv1.ConditionSucceeded,
+			Status: metav1.ConditionTrue,
+			Reason: "LauncherSucceeded",
+		})
+		Expect(k8sClient.Status().Update(ctx, mpiJob)).To(Succeed())
+		reconcileTestMPIJob(ctx, r, mpiJob)
+
+		var launcher corev1.Pod
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: mpiJob.Namespace, Name: mpiJob.Name + render.LauncherSuffix}, &launcher)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(mpiJob.Status.Conditions, batchv1.ConditionSucceeded)).To(BeTrue())
+	})
+
+	It("counts a retried launcher failure once, up to the retry limit", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "retry")
+		mpiJob.Spec.RunPolicy.FailurePolicy = &batchv1.FailurePolicy{
+			MaxRetries: 1,
+			Rules:      []batchv1.FailurePolicyRule{{Action: batchv1.FailureActionRetry, OnExitCodes: []int32{137}}},
+		}
+		Expect(k8sClient.Update(ctx, mpiJob)).To(Succeed())
+
+		launcher := createRunningLauncher(ctx, mpiJob)
+		failLauncher(ctx, launcher, 137)
+		Expect(r.updateLauncherStatus(ctx, mpiJob, launcher)).To(Succeed())
+		Expect(mpiJob.Status.Retries).To(BeEquivalentTo(1))
+		Expect(mpiJob.Status.LastFailure).NotTo(BeNil())
+		Expect(mpiJob.Status.LastFailure.UID).To(Equal(launcher.UID))
+		Expect(render.Finished(mpiJob)).To(BeFalse())
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(launcher), &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		// the deleted launcher is still in the cache
+		Expect(r.updateLauncherStatus(ctx, mpiJob, launcher)).To(Succeed())
+		Expect(mpiJob.Status.Retries).To(BeEquivalentTo(1))
+		Expect(render.Finished(mpiJob)).To(BeFalse())
+
+		launcher = createRunningLauncher(ctx, mpiJob)
+		failLauncher(ctx, launcher, 137)
+		Expect(r.updateLauncherStatus(ctx, mpiJob, launcher)).To(Succeed())
+		Expect(mpiJob.Status.Retries).To(BeEquivalentTo(1))
+		Expect(meta.IsStatusConditionTrue(mpiJob.Status.Conditions, batchv1.ConditionFailed)).To(BeTrue())
+	})
+
+	It("restarts a run with new pods of the next attempt", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "restart")
+		reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(mpiJob.Status.Attempt).To(BeEquivalentTo(1))
+		launcher := createRunningLauncher(ctx, mpiJob)
+		worker := types.NamespacedName{Namespace: mpiJob.Namespace, Name: render.WorkerNames(mpiJob)[0]}
+		Expect(k8sClient.Get(ctx, worker, &corev1.Pod{})).To(Succeed())
+
+		Expect(r.restartRun(ctx, mpiJob, "Test")).To(Succeed())
+		Expect(mpiJob.Status.Attempt).To(BeEquivalentTo(2))
+		Expect(mpiJob.Status.RestartReason).To(Equal("Test"))
+		Expect(mpiJob.Status.StartTime).To(BeNil())
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(launcher), &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, worker, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(mpiJob.Status.Attempt).To(BeEquivalentTo(2))
+		Expect(mpiJob.Status.StartTime).NotTo(BeNil())
+		var pod corev1.Pod
+		Expect(k8sClient.Get(ctx, worker, &pod)).To(Succeed())
+		Expect(pod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: render.AttemptEnv, Value: "2"}))
+	})
+
+	It("replaces an evicted worker and restarts the launcher", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "node-failure")
+		mpiJob.Spec.RunPolicy.NodeFailureTolerationSeconds = int32Ptr(0)
+		Expect(k8sClient.Update(ctx, mpiJob)).To(Succeed())
+		reconcileTestMPIJob(ctx, r, mpiJob)
+		launcher := createRunningLauncher(ctx, mpiJob)
+
+		var pod corev1.Pod
+		worker := types.NamespacedName{Namespace: mpiJob.Namespace, Name: render.WorkerNames(mpiJob)[0]}
+		Expect(k8sClient.Get(ctx, worker, &pod)).To(Succeed())
+		pod.Status.Phase = corev1.PodFailed
+		pod.Status.Reason = "Evicted"
+		Expect(k8sClient.Status().Update(ctx, &pod)).To(Succeed())
+
+		reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(mpiJob.Status.Attempt).To(BeEquivalentTo(2))
+		Expect(mpiJob.Status.RestartReason).To(Equal("NodeFailure"))
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(launcher), &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, worker, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("holds the deletion of an MPIJob until the grace period of its launcher is over", func() {
+		r := newTestReconciler()
+		mpiJob := createTestMPIJob(ctx, "graceful")
+		mpiJob.Spec.RunPolicy.GracefulShutdown = &batchv1.GracefulShutdown{GracePeriodSeconds: 60}
+		Expect(k8sClient.Update(ctx, mpiJob)).To(Succeed())
+		reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(controllerutil.ContainsFinalizer(mpiJob, gracefulShutdownFinalizer)).To(BeTrue())
+		createRunningLauncher(ctx, mpiJob)
+
+		Expect(k8sClient.Delete(ctx, mpiJob)).To(Succeed())
+		// the shutdown time is recorded, though the signal can't be sent
+		// without a kubelet, and the deletion waits
+		result := reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(result.RequeueAfter).To(Equal(shutdownPollInterval))
+		Expect(mpiJob.Status.ShutdownTime).NotTo(BeNil())
+		shutdownTime := mpiJob.Status.ShutdownTime.DeepCopy()
+		result = reconcileTestMPIJob(ctx, r, mpiJob)
+		Expect(result.RequeueAfter).To(Equal(shutdownPollInterval))
+		Expect(mpiJob.Status.ShutdownTime.Equal(shutdownTime)).To(BeTrue())
+
+		// the grace period is over
+		past := metav1.NewTime(time.Now().Add(-time.Minute - time.Second))
+		mpiJob.Status.ShutdownTime = &past
+		Expect(k8sClient.Status().Update(ctx, mpiJob)).To(Succeed())
+		key := types.NamespacedName{Namespace: mpiJob.Namespace, Name: mpiJob.Name}
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Get(ctx, key, mpiJob)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
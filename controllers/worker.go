@@ -3,76 +3,126 @@ package controllers
 import (
 	"context"
 	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func newWorker(mpiJob *v1.MPIJob) *appsv1.StatefulSet {
-	template := *mpiJob.Spec.WorkerTemplate.DeepCopy()
-	if template.Labels == nil {
-		template.Labels = map[string]string{}
-	}
-	template.Labels["app"] = mpiJob.Name + workerSuffix
-	template.Spec.RestartPolicy = corev1.RestartPolicyAlways
-	return &appsv1.StatefulSet{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      mpiJob.Name + workerSuffix,
-			Namespace: mpiJob.Namespace,
-		},
-		Spec: appsv1.StatefulSetSpec{
-			Replicas: mpiJob.Spec.NumWorkers,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": mpiJob.Name + workerSuffix,
-				},
-			},
-			Template:            template,
-			ServiceName:         mpiJob.Name,
-			PodManagementPolicy: appsv1.ParallelPodManagement,
-			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
-				Type: appsv1.RollingUpdateStatefulSetStrategyType,
-			},
-		},
-	}
-}
-
-// return pods, err, and success
-func (r *MPIJobReconciler) getOrCreateWorker(ctx context.Context, mpiJob *v1.MPIJob) (*appsv1.StatefulSet, error) {
+// getOrCreateWorker reconciles the StatefulSet of a worker group and its pods,
+// and reports whether an update of the pods is held.
+func (r *MPIJobReconciler) getOrCreateWorker(ctx context.Context, mpiJob *v1.MPIJob, group *v1.WorkerGroup) (*appsv1.StatefulSet, bool, error) {
 	logger := log.FromContext(ctx)
-	if mpiJob.Spec.WorkerTemplate.Spec.RestartPolicy != corev1.RestartPolicyAlways {
+	if group.Template.Spec.RestartPolicy != corev1.RestartPolicyAlways {
 		logger.Info("WARN:Overwrite RestartPolicy in WorkerTemplate to Always.")
 	}
-	newWorker := newWorker(mpiJob)
-	if err := ctrl.SetControllerReference(mpiJob, newWorker, r.Scheme); err != nil {
-		return nil, err
+	desired := render.Worker(mpiJob, group)
+	// The selector of a StatefulSet can't be changed, e.g. after the labels
+	// of the workers have changed, so an existing StatefulSet keeps its own,
+	// which its pods get too. Replacing it would kill a running MPIJob.
+	var existing appsv1.StatefulSet
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), &existing)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, false, err
 	}
-	var worker appsv1.StatefulSet
-	err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: mpiJob.Name + workerSuffix}, &worker)
-	// If the worker StatefulSet doesn't exist, we'll create it.
-	if errors.IsNotFound(err) {
-		if err := r.Create(ctx, newWorker); err != nil {
-			return nil, err
+	if err == nil && metav1.IsControlledBy(&existing, mpiJob) && existing.Spec.Selector != nil &&
+		!equality.Semantic.DeepEqual(existing.Spec.Selector, desired.Spec.Selector) {
+		logger.Info("WARN: keeping the selector of the worker statefulset", "StatefulSet Name", existing.Name)
+		desired.Spec.Selector = existing.Spec.Selector.DeepCopy()
+		for k, v := range existing.Spec.Selector.MatchLabels {
+			desired.Spec.Template.Labels[k] = v
 		}
-		return newWorker, nil
 	}
+
+	obj, err := r.reconcileObject(ctx, mpiJob, desired, UpdateApply, OwnershipAdopt)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	// If the worker is not controlled by this MPIJob resource, we should log
-	// a warning to the event recorder and return.
-	if !metav1.IsControlledBy(&worker, mpiJob) {
-		logger.Info("WARN:worker statefulset is not controlled by this MPIJob resource. Skipping",
-			"Pod Name", worker.Name)
-		// we don't control this worker pod
-		return nil, nil
+	worker := obj.(*appsv1.StatefulSet)
+	pending, err := r.syncWorkerPods(ctx, mpiJob, group, worker)
+	if err != nil {
+		return nil, false, err
 	}
-	if err := r.Update(ctx, newWorker); err != nil {
-		return nil, err
+	return worker, pending, nil
+}
+
+// holdWorkerUpdates reports whether the launcher of an MPIJob is running, so
+// the worker pods must not be replaced.
+func holdWorkerUpdates(mpiJob *v1.MPIJob) bool {
+	return mpiJob.Status.Launcher != nil && !render.Finished(mpiJob)
+}
+
+// syncWorkerPods adds the replica index label to the pods of the StatefulSet
+// of a worker group, as it differs for every pod of the template, and deletes
+// the pods that don't match the template anymore, for the StatefulSet to
+// create them again. It reports whether some pods are outdated but kept,
+// because the launcher is running.
+func (r *MPIJobReconciler) syncWorkerPods(ctx context.Context, mpiJob *v1.MPIJob, group *v1.WorkerGroup, worker *appsv1.StatefulSet) (bool, error) {
+	logger := log.FromContext(ctx)
+	pending := false
+	for i := 0; i < int(group.Replicas); i++ {
+		var pod corev1.Pod
+		err := r.Get(ctx, client.ObjectKey{Namespace: mpiJob.Namespace, Name: render.WorkerName(mpiJob, group, i)}, &pod)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !metav1.IsControlledBy(&pod, worker) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		revision := worker.Status.UpdateRevision
+		if worker.Status.ObservedGeneration == worker.Generation && revision != "" &&
+			pod.Labels[appsv1.StatefulSetRevisionLabel] != revision {
+			if holdWorkerUpdates(mpiJob) {
+				pending = true
+			} else {
+				logger.Info("updating worker pod", "Pod Name", pod.Name)
+				if err := r.Delete(ctx, &pod); client.IgnoreNotFound(err) != nil {
+					return false, err
+				}
+				continue
+			}
+		}
+		index := render.ReplicaIndex(mpiJob, group, i)
+		if pod.Labels[render.LabelReplicaIndex] == index {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[render.LabelReplicaIndex] = index
+		if err := r.Patch(ctx, &pod, patch); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	return pending, nil
+}
+
+// deleteWorkers deletes the worker StatefulSets controlled by the MPIJob whose
+// name is not in keep. It removes the StatefulSets of dropped worker groups,
+// and all of them when the MPIJob runs in the Pods worker mode.
+func (r *MPIJobReconciler) deleteWorkers(ctx context.Context, mpiJob *v1.MPIJob, keep map[string]bool) error {
+	logger := log.FromContext(ctx)
+	var workers appsv1.StatefulSetList
+	if err := r.List(ctx, &workers, client.InNamespace(mpiJob.Namespace)); err != nil {
+		return err
+	}
+	for i := range workers.Items {
+		worker := &workers.Items[i]
+		if keep[worker.Name] || !metav1.IsControlledBy(worker, mpiJob) || worker.DeletionTimestamp != nil {
+			continue
+		}
+		logger.Info("deleting worker statefulset", "StatefulSet Name", worker.Name)
+		if err := r.Delete(ctx, worker); client.IgnoreNotFound(err) != nil {
+			return err
+		}
 	}
-	return newWorker, nil
+	return nil
 }
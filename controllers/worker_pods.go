@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *MPIJobReconciler) getOrCreateWorkerPod(ctx context.Context, mpiJob *v1.MPIJob, group *v1.WorkerGroup, i int) (*corev1.Pod, error) {
+	logger := log.FromContext(ctx)
+	obj, err := r.reconcileObject(ctx, mpiJob, render.WorkerPod(mpiJob, group, i), UpdateCreateOnly, OwnershipReject)
+	if err != nil {
+		return nil, err
+	}
+	pod := obj.(*corev1.Pod)
+	// A worker that has ended is deleted here and recreated by a later
+	// reconcile, unless the keep-alive entrypoint ended it with the MPIJob.
+	if pod.DeletionTimestamp == nil && !render.WorkersReleased(mpiJob) &&
+		(pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded) {
+		logger.Info("worker pod ended, recreating", "Pod Name", pod.Name, "Phase", pod.Status.Phase)
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+	}
+	return pod, nil
+}
+
+// getOrCreateWorkerPods makes sure every worker Pod of the MPIJob exists, in
+// rank order, and removes the worker Pods that are no longer wanted.
+func (r *MPIJobReconciler) getOrCreateWorkerPods(ctx context.Context, mpiJob *v1.MPIJob) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	keep := map[string]bool{}
+	groups := render.WorkerGroups(mpiJob)
+	for g := range groups {
+		for i := 0; i < int(groups[g].Replicas); i++ {
+			pod, err := r.getOrCreateWorkerPod(ctx, mpiJob, &groups[g], i)
+			if err != nil {
+				return nil, err
+			}
+			pods = append(pods, pod)
+			keep[pod.Name] = true
+		}
+	}
+	if err := r.deleteWorkerPods(ctx, mpiJob, keep); err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// deleteWorkerPods deletes the worker Pods owned directly by the MPIJob whose
+// name is not in keep. Calling it with an empty keep removes all of them,
+// which is needed when switching back to the StatefulSet worker mode.
+func (r *MPIJobReconciler) deleteWorkerPods(ctx context.Context, mpiJob *v1.MPIJob, keep map[string]bool) error {
+	logger := log.FromContext(ctx)
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(mpiJob.Namespace)); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if keep[pod.Name] || !strings.HasPrefix(pod.Name, mpiJob.Name+render.WorkerSuffix) ||
+			!metav1.IsControlledBy(pod, mpiJob) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		logger.Info("deleting worker pod", "Pod Name", pod.Name)
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func podRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, c := range pod.Status.ContainerStatuses {
+		restarts += c.RestartCount
+	}
+	return restarts
+}
+
+func workerStatus(pod *corev1.Pod) v1.WorkerStatus {
+	reason, _ := podStartupProblem(pod)
+	return v1.WorkerStatus{
+		Name:     pod.Name,
+		Phase:    pod.Status.Phase,
+		Ready:    pod.DeletionTimestamp == nil && isPodReady(pod),
+		Restarts: podRestarts(pod),
+		Reason:   reason,
+	}
+}
+
+func workerStatuses(pods []*corev1.Pod) []v1.WorkerStatus {
+	var statuses []v1.WorkerStatus
+	for _, pod := range pods {
+		statuses = append(statuses, workerStatus(pod))
+	}
+	return statuses
+}
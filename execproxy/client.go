@@ -0,0 +1,123 @@
+package execproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Request is a command to run in a pod of an MPIJob.
+type Request struct {
+	Namespace string
+	Job       string
+	Pod       string
+	Command   []string
+}
+
+// StatusError is returned when the proxy refused the request.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("exec proxy: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// StartedError wraps an error that happened after the command has started.
+type StartedError struct {
+	Err error
+}
+
+func (e *StartedError) Error() string { return e.Err.Error() }
+
+func (e *StartedError) Unwrap() error { return e.Err }
+
+// Exec runs a command through the proxy at proxyURL, authenticated with token,
+// and returns its exit code. Errors of the connection after the request has
+// been accepted are returned as a StartedError, as the command may have run.
+func Exec(ctx context.Context, client *http.Client, proxyURL, token string, req *Request,
+	stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	u, err := url.Parse(strings.TrimSuffix(proxyURL, "/") + Path)
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{
+		namespaceParam: {req.Namespace},
+		jobParam:       {req.Job},
+		podParam:       {req.Pod},
+		commandParam:   req.Command,
+	}
+	u.RawQuery = query.Encode()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Connection", "Upgrade")
+	httpReq.Header.Set("Upgrade", Protocol)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return 0, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return 0, fmt.Errorf("exec proxy: the connection can't be written to")
+	}
+	defer conn.Close()
+
+	w := &syncWriter{w: conn}
+	go func() {
+		// the end of stdin is sent as an empty frame
+		_, _ = io.Copy(&frameWriter{w: w, stream: streamStdin}, stdin)
+		_ = w.writeFrame(streamStdin, nil)
+	}()
+
+	for {
+		stream, payload, err := readFrame(conn)
+		if err != nil {
+			return 0, &StartedError{Err: fmt.Errorf("exec proxy: %w", err)}
+		}
+		switch stream {
+		case streamStdout:
+			if _, err := stdout.Write(payload); err != nil {
+				return 0, &StartedError{Err: err}
+			}
+		case streamStderr:
+			if _, err := stderr.Write(payload); err != nil {
+				return 0, &StartedError{Err: err}
+			}
+		case streamExit:
+			if len(payload) != 4 {
+				return 0, &StartedError{Err: fmt.Errorf("exec proxy: invalid exit frame")}
+			}
+			return int(binary.BigEndian.Uint32(payload)), nil
+		case streamError:
+			return 0, &StartedError{Err: fmt.Errorf("exec proxy: %s", payload)}
+		}
+	}
+}
+
+// syncWriter serializes the frames written by several goroutines.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) writeFrame(stream byte, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFrame(s.w, stream, payload)
+}
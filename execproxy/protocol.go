@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package execproxy runs commands in the worker pods of MPIJobs on behalf of
+// their launchers, so the launchers don't need the permission to exec into
+// pods. The proxy runs in the manager and authenticates the launchers with
+// the exec token of their MPIJob.
+//
+// The rsh agent of a launcher sends a POST request to /exec with the token as
+// a bearer token, and the namespace, the MPIJob, the pod and the command as
+// query parameters. When the command is allowed, the proxy switches the
+// connection to the mpi-exec protocol: both sides send frames made of a
+// stream byte, the big-endian uint32 length of the payload, and the payload.
+// The agent sends stdin, an empty stdin frame being the end of stdin, and the
+// proxy sends stdout, stderr, and finally the exit code or an error.
+package execproxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// Path is the path of the exec endpoint.
+	Path = "/exec"
+	// Protocol is the protocol the connection switches to.
+	Protocol = "mpi-exec"
+
+	// the query parameters of the request
+	namespaceParam = "namespace"
+	jobParam       = "job"
+	podParam       = "pod"
+	commandParam   = "command"
+
+	// maxFrameSize bounds the memory used by a frame.
+	maxFrameSize = 1 << 20
+)
+
+// The streams of the frames.
+const (
+	streamStdin byte = iota
+	streamStdout
+	streamStderr
+	// streamExit holds the exit code of the command as a big-endian uint32.
+	streamExit
+	// streamError holds an error that prevented to run the command.
+	streamError
+)
+
+func writeFrame(w io.Writer, stream byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// frameWriter writes the data written to it as frames of a stream.
+type frameWriter struct {
+	w      *syncWriter
+	stream byte
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		if err := f.w.writeFrame(f.stream, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,119 @@
+package execproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  byte
+		payload []byte
+	}{
+		{name: "stdout", stream: streamStdout, payload: []byte("hello\n")},
+		{name: "end of stdin", stream: streamStdin, payload: []byte{}},
+		{name: "exit code", stream: streamExit, payload: []byte{0, 0, 0, 3}},
+		{name: "largest", stream: streamStderr, payload: bytes.Repeat([]byte{'x'}, maxFrameSize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeFrame(&buf, tt.stream, tt.payload); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() != 5+len(tt.payload) {
+				t.Errorf("got %d bytes, want %d", buf.Len(), 5+len(tt.payload))
+			}
+			stream, payload, err := readFrame(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stream != tt.stream || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("got stream %d with %d bytes, want stream %d with %d bytes",
+					stream, len(payload), tt.stream, len(tt.payload))
+			}
+		})
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	header := func(stream byte, size uint32) []byte {
+		h := []byte{stream, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(h[1:], size)
+		return h
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+		wantMsg string
+	}{
+		{name: "end of stream", data: nil, wantErr: io.EOF},
+		{name: "short header", data: []byte{streamStdout, 0}, wantErr: io.ErrUnexpectedEOF},
+		{name: "short payload", data: append(header(streamStdout, 4), 'a'), wantErr: io.ErrUnexpectedEOF},
+		{name: "too large", data: header(streamStdout, maxFrameSize+1), wantMsg: "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readFrame(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("got %v, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestFrameWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantFrames []int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 10, wantFrames: []int{10}},
+		{name: "split", size: maxFrameSize + 10, wantFrames: []int{maxFrameSize, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &frameWriter{w: &syncWriter{w: &buf}, stream: streamStdout}
+			n, err := w.Write(bytes.Repeat([]byte{'x'}, tt.size))
+			if err != nil || n != tt.size {
+				t.Fatalf("wrote %d bytes with %v, want %d", n, err, tt.size)
+			}
+			var frames []int
+			for {
+				stream, payload, err := readFrame(&buf)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stream != streamStdout {
+					t.Errorf("got stream %d, want %d", stream, streamStdout)
+				}
+				frames = append(frames, len(payload))
+			}
+			if len(frames) != len(tt.wantFrames) {
+				t.Fatalf("got frames of %v bytes, want %v", frames, tt.wantFrames)
+			}
+			for i := range frames {
+				if frames[i] != tt.wantFrames[i] {
+					t.Errorf("got frames of %v bytes, want %v", frames, tt.wantFrames)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package execproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Server is the exec proxy. It is a Runnable of the manager.
+type Server struct {
+	// Reader reads the MPIJobs, their exec tokens and their pods.
+	Reader client.Reader
+	// Config and KubeClient are used to exec into the pods.
+	Config     *rest.Config
+	KubeClient kubernetes.Interface
+	// BindAddress is the address the proxy listens on.
+	BindAddress string
+	// CertFile and KeyFile are the TLS certificate and key of the proxy, which
+	// is only served over TLS, as the exec tokens are bearer tokens.
+	CertFile string
+	KeyFile  string
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable, so every replica of
+// the manager serves the proxy.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the proxy until the context is done.
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("exec-proxy")
+	if s.CertFile == "" || s.KeyFile == "" {
+		return fmt.Errorf("the exec proxy needs a TLS certificate and key")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	srv := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return log.IntoContext(context.Background(), logger) },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("starting exec proxy", "Address", s.BindAddress)
+		errCh <- srv.ListenAndServeTLS(s.CertFile, s.KeyFile)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// ServeHTTP authenticates and authorizes an exec request, switches the
+// connection to the mpi-exec protocol and runs the command.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	req := &Request{
+		Namespace: query.Get(namespaceParam),
+		Job:       query.Get(jobParam),
+		Pod:       query.Get(podParam),
+		Command:   query[commandParam],
+	}
+	logger := log.FromContext(ctx).WithValues("MPIJob", req.Namespace+"/"+req.Job, "Pod", req.Pod,
+		"Remote", r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Namespace == "" || req.Job == "" || req.Pod == "" || len(req.Command) == 0 {
+		http.Error(w, "namespace, job, pod and command are required", http.StatusBadRequest)
+		return
+	}
+	pod, code, err := s.authorize(ctx, r, req)
+	if err != nil {
+		// every refused command is audited too
+		logger.Info("exec refused", "Command", req.Command, "Reason", err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
+	logger.Info("exec", "Command", req.Command)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "the connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		logger.Error(err, "can't hijack the connection")
+		return
+	}
+	defer conn.Close()
+	_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + Protocol + "\r\n\r\n")
+	if err := buf.Flush(); err != nil {
+		return
+	}
+
+	out := &syncWriter{w: conn}
+	exitCode, err := s.exec(ctx, pod, req.Command, buf.Reader, out)
+	if err != nil {
+		logger.Error(err, "exec failed")
+		_ = out.writeFrame(streamError, []byte(err.Error()))
+		return
+	}
+	logger.Info("exec done", "ExitCode", exitCode)
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(exitCode))
+	_ = out.writeFrame(streamExit, payload)
+}
+
+// authorize checks the exec token of the request and that the pod is a host
+// of the MPIJob, and returns the pod.
+func (s *Server) authorize(ctx context.Context, r *http.Request, req *Request) (*corev1.Pod, int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil, http.StatusUnauthorized, errors.New("missing exec token")
+	}
+	var mpiJob v1.MPIJob
+	if err := s.Reader.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Job}, &mpiJob); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, http.StatusForbidden, errors.New("invalid exec token")
+		}
+		return nil, http.StatusServiceUnavailable, err
+	}
+	var secret corev1.Secret
+	err := s.Reader.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Job + render.ExecTokenSuffix}, &secret)
+	if apierrors.IsNotFound(err) {
+		return nil, http.StatusForbidden, errors.New("invalid exec token")
+	}
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	if !metav1.IsControlledBy(&secret, &mpiJob) ||
+		subtle.ConstantTimeCompare(secret.Data[render.ExecTokenKey], []byte(token)) != 1 {
+		return nil, http.StatusForbidden, errors.New("invalid exec token")
+	}
+
+	hosts := render.WorkerNames(&mpiJob)
+	if mpiJob.Spec.RunLauncherAsWorker {
+		hosts = append(hosts, mpiJob.Name+render.LauncherSuffix)
+	}
+	allowed := false
+	for _, host := range hosts {
+		allowed = allowed || host == req.Pod
+	}
+	if !allowed {
+		return nil, http.StatusForbidden, fmt.Errorf("pod %s is not a host of the MPIJob", req.Pod)
+	}
+	var pod corev1.Pod
+	err = s.Reader.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Pod}, &pod)
+	if apierrors.IsNotFound(err) {
+		return nil, http.StatusNotFound, fmt.Errorf("pod %s not found", req.Pod)
+	}
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	// the labels of a pod can be set by anyone who can create pods
+	owned, err := s.controlledByJob(ctx, &pod, &mpiJob)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	if !owned {
+		return nil, http.StatusForbidden, fmt.Errorf("pod %s doesn't belong to the MPIJob", req.Pod)
+	}
+	if render.ExecContainer(pod.Annotations, &pod.Spec) < 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("pod %s has no container to run the command in", req.Pod)
+	}
+	return &pod, 0, nil
+}
+
+// controlledByJob reports whether a pod is controlled by an MPIJob, as its
+// launcher or one of its workers in the Pods worker mode, or by one of its
+// worker StatefulSets.
+func (s *Server) controlledByJob(ctx context.Context, pod *corev1.Pod, mpiJob *v1.MPIJob) (bool, error) {
+	if metav1.IsControlledBy(pod, mpiJob) {
+		return true, nil
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "StatefulSet" {
+		return false, nil
+	}
+	var worker appsv1.StatefulSet
+	err := s.Reader.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: owner.Name}, &worker)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return worker.UID == owner.UID && metav1.IsControlledBy(&worker, mpiJob), nil
+}
+
+// exec runs a command in the pod with the exec API, like the rsh agent does
+// without the proxy, reading the stdin frames from in and writing the stdout
+// and stderr frames to out.
+func (s *Server) exec(ctx context.Context, pod *corev1.Pod, command []string, in io.Reader, out *syncWriter) (int, error) {
+	container := pod.Spec.Containers[render.ExecContainer(pod.Annotations, &pod.Spec)].Name
+	execReq := s.KubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   []string{"/bin/sh", "-c", strings.Join(command, " ")},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(s.Config, "POST", execReq.URL())
+	if err != nil {
+		return 0, err
+	}
+
+	stdin, stdinWriter := io.Pipe()
+	go func() {
+		// forward the stdin frames until the empty one
+		for {
+			stream, payload, err := readFrame(in)
+			if err != nil {
+				stdinWriter.CloseWithError(err)
+				return
+			}
+			if stream != streamStdin {
+				continue
+			}
+			if len(payload) == 0 {
+				stdinWriter.Close()
+				return
+			}
+			if _, err := stdinWriter.Write(payload); err != nil {
+				return
+			}
+		}
+	}()
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &frameWriter{w: out, stream: streamStdout},
+		Stderr: &frameWriter{w: out, stream: streamStderr},
+	})
+	stdin.Close()
+	var exitErr exec.CodeExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,63 @@
+package execproxy
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"github.com/FFFFFaraway/MPI-Operator/render"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestControlledByJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1.AddToScheme(scheme)
+	controller := true
+	ownedBy := func(kind, name string, uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name, UID: uid, Controller: &controller}}
+	}
+	mpiJob := &v1.MPIJob{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns", UID: "job-uid"}}
+	worker := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "job-worker", Namespace: "ns", UID: "worker-uid",
+		OwnerReferences: ownedBy("MPIJob", "job", "job-uid"),
+	}}
+	other := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns", UID: "other-uid"}}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(worker, other).Build()
+	s := &Server{Reader: reader}
+
+	tests := []struct {
+		name   string
+		owners []metav1.OwnerReference
+		want   bool
+	}{
+		{name: "launcher", owners: ownedBy("MPIJob", "job", "job-uid"), want: true},
+		{name: "worker of the StatefulSet", owners: ownedBy("StatefulSet", "job-worker", "worker-uid"), want: true},
+		{name: "only the label"},
+		{name: "another MPIJob", owners: ownedBy("MPIJob", "job", "another-uid")},
+		{name: "another StatefulSet", owners: ownedBy("StatefulSet", "other", "other-uid")},
+		{name: "a replaced StatefulSet", owners: ownedBy("StatefulSet", "job-worker", "old-uid")},
+		{name: "a missing StatefulSet", owners: ownedBy("StatefulSet", "gone", "gone-uid")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name: "pod", Namespace: "ns", OwnerReferences: tt.owners,
+				Labels: map[string]string{render.LabelJobName: "job"},
+			}}
+			got, err := s.controlledByJob(context.Background(), pod, mpiJob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,21 +18,28 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	batchv1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
 	"github.com/FFFFFaraway/MPI-Operator/controllers"
+	"github.com/FFFFFaraway/MPI-Operator/execproxy"
+	"github.com/FFFFFaraway/MPI-Operator/render"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,11 +59,27 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var launcherLogTailLines int64
+	var agentImage string
+	var execProxyAddr, execProxyURL, execProxyCertFile, execProxyKeyFile, execProxyCAFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.Int64Var(&launcherLogTailLines, "launcher-log-tail-lines", 50,
+		"The number of lines of the log of a failed launcher recorded in the MPIJob status.")
+	flag.StringVar(&agentImage, "agent-image", render.DefaultAgentImage,
+		"The image that delivers the rsh agent to the launchers.")
+	flag.StringVar(&execProxyAddr, "exec-proxy-bind-address", "",
+		"The address the exec proxy binds to. The proxy is disabled when empty.")
+	flag.StringVar(&execProxyURL, "exec-proxy-url", "",
+		"The https URL the launchers reach the exec proxy at, e.g. https://mpi-exec-proxy.sw-mpi-operator.svc:9444. "+
+			"When set, the launchers exec into the workers through the proxy instead of the API server.")
+	flag.StringVar(&execProxyCertFile, "exec-proxy-cert-file", "", "The TLS certificate of the exec proxy.")
+	flag.StringVar(&execProxyKeyFile, "exec-proxy-key-file", "", "The TLS key of the exec proxy.")
+	flag.StringVar(&execProxyCAFile, "exec-proxy-ca-file", "",
+		"The CA bundle the launchers verify the certificate of the exec proxy with, besides the system roots.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +88,20 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := validateExecProxyFlags(execProxyAddr, execProxyURL, execProxyCertFile, execProxyKeyFile); err != nil {
+		setupLog.Error(err, "invalid exec proxy flags")
+		os.Exit(1)
+	}
+	var execProxyCA string
+	if execProxyCAFile != "" {
+		ca, err := os.ReadFile(execProxyCAFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read the exec proxy CA")
+			os.Exit(1)
+		}
+		execProxyCA = string(ca)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -72,19 +109,43 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "79a101cf.test.bdap.com",
+		// only the exec tokens are read, which isn't worth a cache of every
+		// Secret of the cluster
+		ClientDisableCacheFor: []client.Object{&corev1.Secret{}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	kubeClient := kubernetes.NewForConfigOrDie(mgr.GetConfig())
 	if err = (&controllers.MPIJobReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Scheme:               mgr.GetScheme(),
+		KubeClient:           kubeClient,
+		Config:               mgr.GetConfig(),
+		LauncherLogTailLines: launcherLogTailLines,
+		AgentImage:           agentImage,
+		ExecProxyURL:         execProxyURL,
+		ExecProxyCA:          execProxyCA,
+		ManagerNamespace:     os.Getenv("POD_NAMESPACE"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MPIJob")
 		os.Exit(1)
 	}
+	if execProxyAddr != "" {
+		if err := mgr.Add(&execproxy.Server{
+			Reader:      mgr.GetAPIReader(),
+			Config:      mgr.GetConfig(),
+			KubeClient:  kubeClient,
+			BindAddress: execProxyAddr,
+			CertFile:    execProxyCertFile,
+			KeyFile:     execProxyKeyFile,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up exec proxy")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -102,3 +163,24 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// validateExecProxyFlags checks that the exec proxy is either disabled, or
+// served and used over TLS, as the exec tokens are bearer tokens.
+func validateExecProxyFlags(addr, url, certFile, keyFile string) error {
+	if addr == "" && url == "" {
+		if certFile != "" || keyFile != "" {
+			return fmt.Errorf("--exec-proxy-cert-file and --exec-proxy-key-file need --exec-proxy-bind-address")
+		}
+		return nil
+	}
+	if addr == "" || url == "" {
+		return fmt.Errorf("--exec-proxy-bind-address and --exec-proxy-url must be set together")
+	}
+	if !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("--exec-proxy-url must be an https URL")
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("the exec proxy needs --exec-proxy-cert-file and --exec-proxy-key-file")
+	}
+	return nil
+}
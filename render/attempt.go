@@ -0,0 +1,43 @@
+package render
+
+import (
+	"strconv"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The environment of the containers of the launcher and the workers, to
+// resume the training in a new attempt.
+const (
+	AttemptEnv               = "MPIJOB_ATTEMPT"
+	RestartReasonEnv         = "MPIJOB_RESTART_REASON"
+	DefaultCheckpointPathEnv = "MPIJOB_CHECKPOINT_PATH"
+)
+
+// addAttemptEnv adds the attempt of an MPIJob, why it was started, and the
+// path of the checkpoints, if any, to the environment of all the containers
+// and init containers of a pod.
+func addAttemptEnv(mpiJob *v1.MPIJob, spec *corev1.PodSpec) {
+	attempt := mpiJob.Status.Attempt
+	if attempt < 1 {
+		attempt = 1
+	}
+	env := []corev1.EnvVar{
+		{Name: AttemptEnv, Value: strconv.Itoa(int(attempt))},
+		{Name: RestartReasonEnv, Value: mpiJob.Status.RestartReason},
+	}
+	if checkpoint := mpiJob.Spec.Checkpoint; checkpoint != nil {
+		name := checkpoint.EnvName
+		if name == "" {
+			name = DefaultCheckpointPathEnv
+		}
+		env = append(env, corev1.EnvVar{Name: name, Value: checkpoint.Path})
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].Env = append(spec.InitContainers[i].Env, env...)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].Env = append(spec.Containers[i].Env, env...)
+	}
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hostfileSlots returns the slots of a hostfile entry, 1 if they are not set.
+func hostfileSlots(slots int32) int32 {
+	if slots < 1 {
+		return 1
+	}
+	return slots
+}
+
+// ConfigMap creates the ConfigMap of an MPIJob, with the hostfile used by
+// mpirun, and the keep-alive entrypoint of the workers when they run it. The
+// finished key is added when the MPIJob is done, to stop the entrypoint, and
+// the source commit once the git source is pinned.
+func ConfigMap(mpiJob *v1.MPIJob) *corev1.ConfigMap {
+	// the ranks follow the order of the worker groups, after the launcher
+	// when it runs as a worker
+	var buffer bytes.Buffer
+	if mpiJob.Spec.RunLauncherAsWorker {
+		buffer.WriteString(fmt.Sprintf("%s slots=%d\n", mpiJob.Name+LauncherSuffix, hostfileSlots(mpiJob.Spec.LauncherSlots)))
+	}
+	groups := WorkerGroups(mpiJob)
+	for g := range groups {
+		for i := 0; i < int(groups[g].Replicas); i++ {
+			buffer.WriteString(fmt.Sprintf("%s slots=%d\n", WorkerName(mpiJob, &groups[g], i), hostfileSlots(groups[g].Slots)))
+		}
+	}
+
+	data := map[string]string{
+		hostfileName: buffer.String(),
+	}
+	if commit := SourceCommit(mpiJob); commit != "" {
+		data[sourceCommitName] = commit
+	}
+	if managedEntrypoint(mpiJob) {
+		data[workerEntrypointName] = workerEntrypointScript
+		if Finished(mpiJob) {
+			condition := v1.ConditionSucceeded
+			if meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionFailed) {
+				condition = v1.ConditionFailed
+			}
+			data[finishedName] = condition
+		}
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mpiJob.Name + ConfigSuffix,
+			Namespace: mpiJob.Namespace,
+			Labels:    Labels(mpiJob, ""),
+		},
+		Data: data,
+	}
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigMap(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	git := &v1.Source{Git: &v1.GitSource{URL: "https://git/repo", Revision: "main"}}
+	tests := []struct {
+		name         string
+		spec         v1.MPIJobSpec
+		status       v1.MPIJobStatus
+		wantHostfile string
+		wantCommit   string
+		wantFinished string
+	}{
+		{
+			name:         "workers",
+			spec:         v1.MPIJobSpec{NumWorkers: int32Ptr(2)},
+			wantHostfile: "job-worker-0 slots=1\njob-worker-1 slots=1\n",
+		},
+		{
+			name:         "launcher as worker",
+			spec:         v1.MPIJobSpec{NumWorkers: int32Ptr(1), RunLauncherAsWorker: true, LauncherSlots: 2},
+			wantHostfile: "job-launcher slots=2\njob-worker-0 slots=1\n",
+		},
+		{
+			name: "worker groups in order",
+			spec: v1.MPIJobSpec{WorkerGroups: []v1.WorkerGroup{
+				{Name: "gpu", Replicas: 1, Slots: 4},
+				{Name: "cpu", Replicas: 2},
+			}},
+			wantHostfile: "job-worker-gpu-0 slots=4\njob-worker-cpu-0 slots=1\njob-worker-cpu-1 slots=1\n",
+		},
+		{
+			name:         "pinned commit",
+			spec:         v1.MPIJobSpec{NumWorkers: int32Ptr(1), Source: git},
+			status:       v1.MPIJobStatus{Source: &v1.SourceStatus{URL: "https://git/repo", Revision: "main", Commit: commit}},
+			wantHostfile: "job-worker-0 slots=1\n",
+			wantCommit:   commit,
+		},
+		{
+			name:         "commit pinned for another revision",
+			spec:         v1.MPIJobSpec{NumWorkers: int32Ptr(1), Source: git},
+			status:       v1.MPIJobStatus{Source: &v1.SourceStatus{URL: "https://git/repo", Revision: "dev", Commit: commit}},
+			wantHostfile: "job-worker-0 slots=1\n",
+		},
+		{
+			name: "finished with the keep-alive entrypoint",
+			spec: v1.MPIJobSpec{NumWorkers: int32Ptr(1), WorkerCommandPolicy: v1.WorkerCommandReplace},
+			status: v1.MPIJobStatus{Conditions: []metav1.Condition{
+				{Type: v1.ConditionFailed, Status: metav1.ConditionTrue},
+			}},
+			wantHostfile: "job-worker-0 slots=1\n",
+			wantFinished: v1.ConditionFailed,
+		},
+		{
+			name: "finished without the keep-alive entrypoint",
+			spec: v1.MPIJobSpec{NumWorkers: int32Ptr(1)},
+			status: v1.MPIJobStatus{Conditions: []metav1.Condition{
+				{Type: v1.ConditionSucceeded, Status: metav1.ConditionTrue},
+			}},
+			wantHostfile: "job-worker-0 slots=1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+				Spec:       tt.spec,
+				Status:     tt.status,
+			}
+			data := ConfigMap(mpiJob).Data
+			if data[hostfileName] != tt.wantHostfile {
+				t.Errorf("got hostfile %q, want %q", data[hostfileName], tt.wantHostfile)
+			}
+			if data[sourceCommitName] != tt.wantCommit {
+				t.Errorf("got commit %q, want %q", data[sourceCommitName], tt.wantCommit)
+			}
+			if data[finishedName] != tt.wantFinished {
+				t.Errorf("got finished %q, want %q", data[finishedName], tt.wantFinished)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const (
+	// the keys of the ConfigMap used by the keep-alive entrypoint
+	workerEntrypointName = "worker-entrypoint.sh"
+	finishedName         = "finished"
+	// workerReadyFile is created by the entrypoint once the setup is done.
+	workerReadyFile = "/tmp/mpi-worker-ready"
+)
+
+// workerEntrypointScript keeps the worker container running until the MPIJob
+// is done, which the controller signals with the finished key of the
+// ConfigMap. It first runs its arguments, if any, as a setup step. SIGTERM
+// stops it, and the setup, with exit code 0.
+const workerEntrypointScript = `#!/bin/sh
+pid=
+trap '[ -n "$pid" ] && kill "$pid" 2>/dev/null; exit 0' TERM INT
+if [ $# -gt 0 ]; then
+  "$@" &
+  pid=$!
+  wait "$pid" || exit $?
+  pid=
+fi
+touch ` + workerReadyFile + `
+while [ ! -e ` + configMountPath + `/` + finishedName + ` ]; do
+  sleep 5 &
+  pid=$!
+  wait "$pid"
+  pid=
+done
+echo "MPIJob $(cat ` + configMountPath + `/` + finishedName + `), exiting"
+`
+
+// Finished reports whether the launcher of an MPIJob has succeeded or failed.
+func Finished(mpiJob *v1.MPIJob) bool {
+	return meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionSucceeded) ||
+		meta.IsStatusConditionTrue(mpiJob.Status.Conditions, v1.ConditionFailed)
+}
+
+// WorkersReleased reports whether the workers of an MPIJob are done: the
+// MPIJob has finished and they run the keep-alive entrypoint, which exits.
+// The StatefulSets are then scaled down, and the ended worker Pods are not
+// recreated.
+func WorkersReleased(mpiJob *v1.MPIJob) bool {
+	return Finished(mpiJob) && managedEntrypoint(mpiJob)
+}
+
+func managedEntrypoint(mpiJob *v1.MPIJob) bool {
+	policy := mpiJob.Spec.WorkerCommandPolicy
+	return policy == v1.WorkerCommandReplace || policy == v1.WorkerCommandWrap
+}
+
+// addWorkerEntrypoint makes the container the rsh agent runs the commands in
+// run the keep-alive entrypoint, with the command of the template as setup
+// step in the Wrap policy.
+func addWorkerEntrypoint(mpiJob *v1.MPIJob, annotations map[string]string, spec *corev1.PodSpec) {
+	i := ExecContainer(annotations, spec)
+	if !managedEntrypoint(mpiJob) || i < 0 {
+		return
+	}
+	container := &spec.Containers[i]
+	var setup []string
+	if mpiJob.Spec.WorkerCommandPolicy == v1.WorkerCommandWrap {
+		setup = append(append(setup, container.Command...), container.Args...)
+	}
+	container.Command = []string{"/bin/sh", configMountPath + "/" + workerEntrypointName}
+	container.Args = setup
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      configVolumeName,
+		MountPath: configMountPath,
+		ReadOnly:  true,
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: configVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: mpiJob.Name + ConfigSuffix,
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,25 @@
+package render
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ExecTokenSecret creates the Secret with a new random exec token for an
+// MPIJob. The controller only creates it once, so the token doesn't change
+// during the life of the MPIJob.
+func ExecTokenSecret(mpiJob *v1.MPIJob) (*corev1.Secret, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &corev1.Secret{
+		ObjectMeta: getObjectMeta(mpiJob, ExecTokenSuffix),
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			ExecTokenKey: []byte(hex.EncodeToString(token)),
+		},
+	}, nil
+}
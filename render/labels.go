@@ -0,0 +1,100 @@
+package render
+
+import (
+	"strconv"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The labels of the objects created for an MPIJob.
+const (
+	LabelName      = "app.kubernetes.io/name"
+	LabelInstance  = "app.kubernetes.io/instance"
+	LabelComponent = "app.kubernetes.io/component"
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// LabelJobName is the name of the MPIJob.
+	LabelJobName = "mpi-job-name"
+	// LabelJobRole is RoleLauncher, RoleWorker or RolePreflight, on the pods
+	// and the worker StatefulSets.
+	LabelJobRole = "mpi-job-role"
+	// LabelWorkerGroup is the name of the StatefulSet of the worker group
+	// of a worker.
+	LabelWorkerGroup = "mpi-job-worker-group"
+	// LabelReplicaIndex is the index of a worker among all the hosts of the
+	// MPIJob, in the order of the hostfile.
+	LabelReplicaIndex = "mpi-job-replica-index"
+
+	RoleLauncher  = "launcher"
+	RoleWorker    = "worker"
+	RolePreflight = "preflight"
+
+	labelNameValue      = "mpi-job"
+	labelManagedByValue = "mpi-operator"
+)
+
+// Labels returns the labels of an object created for an MPIJob. The role is
+// empty for the objects shared by the launcher and the workers.
+func Labels(mpiJob *v1.MPIJob, role string) map[string]string {
+	l := map[string]string{
+		LabelName:      labelNameValue,
+		LabelInstance:  mpiJob.Name,
+		LabelManagedBy: labelManagedByValue,
+		LabelJobName:   mpiJob.Name,
+	}
+	if role != "" {
+		l[LabelComponent] = role
+		l[LabelJobRole] = role
+	}
+	return l
+}
+
+// WorkerLabels returns the labels of the pods of a worker group, without the
+// replica index, which are also the selector of its StatefulSet.
+func WorkerLabels(mpiJob *v1.MPIJob, group *v1.WorkerGroup) map[string]string {
+	l := Labels(mpiJob, RoleWorker)
+	l[LabelWorkerGroup] = WorkerGroupName(mpiJob, group)
+	return l
+}
+
+// ReplicaIndex returns the value of the LabelReplicaIndex label of the i-th
+// worker of a group: its line in the hostfile, after the launcher when it runs
+// as a worker.
+func ReplicaIndex(mpiJob *v1.MPIJob, group *v1.WorkerGroup, i int) string {
+	index := i
+	if mpiJob.Spec.RunLauncherAsWorker {
+		index++
+	}
+	for _, g := range WorkerGroups(mpiJob) {
+		if g.Name == group.Name {
+			break
+		}
+		index += int(g.Replicas)
+	}
+	return strconv.Itoa(index)
+}
+
+// SelectorLabels returns the labels that select all the pods of an MPIJob.
+func SelectorLabels(mpiJob *v1.MPIJob) map[string]string {
+	return map[string]string{
+		LabelName:    labelNameValue,
+		LabelJobName: mpiJob.Name,
+	}
+}
+
+// Selector returns the selector of all the pods of an MPIJob.
+func Selector(mpiJob *v1.MPIJob) labels.Selector {
+	return labels.SelectorFromSet(SelectorLabels(mpiJob))
+}
+
+// mergeLabels returns the labels of a template with the given labels added.
+func mergeLabels(template map[string]string, add map[string]string) map[string]string {
+	l := map[string]string{}
+	for k, v := range template {
+		l[k] = v
+	}
+	for k, v := range add {
+		l[k] = v
+	}
+	return l
+}
@@ -0,0 +1,38 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReplicaIndex(t *testing.T) {
+	groups := []v1.WorkerGroup{
+		{Name: "gpu", Replicas: 2},
+		{Name: "cpu", Replicas: 3},
+	}
+	tests := []struct {
+		name     string
+		launcher bool
+		group    int
+		i        int
+		want     string
+	}{
+		{name: "first group", group: 0, i: 1, want: "1"},
+		{name: "second group", group: 1, i: 0, want: "2"},
+		{name: "launcher as worker", launcher: true, group: 0, i: 0, want: "1"},
+		{name: "second group after the launcher", launcher: true, group: 1, i: 2, want: "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job"},
+				Spec:       v1.MPIJobSpec{WorkerGroups: groups, RunLauncherAsWorker: tt.launcher},
+			}
+			if got := ReplicaIndex(mpiJob, &groups[tt.group], tt.i); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
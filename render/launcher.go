@@ -0,0 +1,212 @@
+package render
+
+import (
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Launcher creates the launcher Pod of an MPIJob. The agent delivery init
+// container, run from the agent image, copies the rsh agent into the
+// launcher. The agent calls the exec API of the API server with the bound
+// token of the launcher, or the exec proxy with the exec token of the MPIJob.
+func Launcher(mpiJob *v1.MPIJob, opts Options) (*corev1.Pod, error) {
+	podSpec := mpiJob.Spec.LauncherTemplate.DeepCopy()
+	addWorkspace(mpiJob, &podSpec.Spec)
+	addAttemptEnv(mpiJob, &podSpec.Spec)
+	addSource(mpiJob, &podSpec.Spec)
+	podSpec.Spec.ServiceAccountName = mpiJob.Name + LauncherSuffix
+	if mpiJob.Spec.RunLauncherAsWorker {
+		// mpirun only starts the local ranks itself if the hostname matches
+		// the launcher entry of the hostfile
+		podSpec.Spec.Hostname = mpiJob.Name + LauncherSuffix
+	}
+	podSpec.Spec.InitContainers = append(podSpec.Spec.InitContainers, corev1.Container{
+		Name:            agentDeliveryName,
+		Image:           opts.AgentImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/" + agentBinaryName, "--install", agentMountPath},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      agentVolumeName,
+				MountPath: agentMountPath,
+			},
+		},
+		Resources: initContainerResources(),
+	})
+	addLauncherReadiness(mpiJob, &podSpec.Spec)
+
+	if len(podSpec.Spec.Containers) == 0 {
+		err := fmt.Errorf("launcher pod does not have any containers in its spec")
+		return nil, err
+	}
+	container := podSpec.Spec.Containers[0]
+	container.Env = append(container.Env,
+		corev1.EnvVar{
+			Name:  "OMPI_MCA_plm_rsh_agent",
+			Value: fmt.Sprintf("%s/%s", agentMountPath, agentBinaryName),
+		},
+		corev1.EnvVar{
+			Name:  "OMPI_MCA_orte_default_hostfile",
+			Value: fmt.Sprintf("%s/%s", configMountPath, hostfileName),
+		},
+	)
+
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{
+			Name:      agentVolumeName,
+			MountPath: agentMountPath,
+		},
+		corev1.VolumeMount{
+			Name:      configVolumeName,
+			MountPath: configMountPath,
+		})
+	tokenVolume := addAgentCredentials(mpiJob, opts, &container)
+	podSpec.Spec.Containers[0] = container
+	automount := false
+	podSpec.Spec.AutomountServiceAccountToken = &automount
+
+	hostfileMode := int32(0444)
+	podSpec.Spec.Volumes = append(podSpec.Spec.Volumes,
+		corev1.Volume{
+			Name: agentVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+		corev1.Volume{
+			Name: configVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					// [sw] find the configmap
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: mpiJob.Name + ConfigSuffix,
+					},
+					Items: []corev1.KeyToPath{
+						{
+							Key:  hostfileName,
+							Path: hostfileName,
+							Mode: &hostfileMode,
+						},
+					},
+				},
+			},
+		},
+		tokenVolume)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        mpiJob.Name + LauncherSuffix,
+			Namespace:   mpiJob.Namespace,
+			Labels:      mergeLabels(podSpec.Labels, Labels(mpiJob, RoleLauncher)),
+			Annotations: podSpec.Annotations,
+		},
+		Spec: podSpec.Spec,
+	}, nil
+}
+
+// addAgentCredentials mounts the credentials the rsh agent uses in a
+// container, and returns their volume: the bound token of the pod, or the
+// exec token of the MPIJob with the exec proxy.
+func addAgentCredentials(mpiJob *v1.MPIJob, opts Options, container *corev1.Container) corev1.Volume {
+	if opts.ExecProxyURL == "" {
+		// where the agent looks for the in-cluster credentials
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      tokenVolumeName,
+			MountPath: tokenMountPath,
+			ReadOnly:  true,
+		})
+		return launcherTokenVolume()
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      execTokenVolumeName,
+		MountPath: ExecTokenMountPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: ExecProxyURLEnv, Value: opts.ExecProxyURL},
+		corev1.EnvVar{Name: JobNameEnv, Value: mpiJob.Name},
+		corev1.EnvVar{Name: JobNamespaceEnv, Value: mpiJob.Namespace},
+	)
+	if opts.ExecProxyCA != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: ExecProxyCAEnv, Value: opts.ExecProxyCA})
+	}
+	return execTokenVolume(mpiJob)
+}
+
+// launcherTokenVolume returns a projected volume with the files of a service
+// account token volume, but with a token bound to the launcher pod that
+// expires after LauncherTokenExpirationSeconds. The kubelet refreshes the
+// token before it expires.
+func launcherTokenVolume() corev1.Volume {
+	expiration := int64(LauncherTokenExpirationSeconds)
+	return corev1.Volume{
+		Name: tokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Path:              "token",
+							ExpirationSeconds: &expiration,
+						},
+					},
+					{
+						ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+							Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+						},
+					},
+					{
+						DownwardAPI: &corev1.DownwardAPIProjection{
+							Items: []corev1.DownwardAPIVolumeFile{
+								{
+									Path:     "namespace",
+									FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// execTokenVolume returns the volume with the exec token of an MPIJob.
+func execTokenVolume(mpiJob *v1.MPIJob) corev1.Volume {
+	mode := int32(0444)
+	return corev1.Volume{
+		Name: execTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: mpiJob.Name + ExecTokenSuffix,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  ExecTokenKey,
+						Path: ExecTokenKey,
+						Mode: &mode,
+					},
+				},
+			},
+		},
+	}
+}
+
+// initContainerResources returns the resources of the init containers added
+// by the controller.
+func initContainerResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse(initContainerCpu),
+			corev1.ResourceMemory:           resource.MustParse(initContainerMem),
+			corev1.ResourceEphemeralStorage: resource.MustParse(initContainerEphStorage),
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse(initContainerCpu),
+			corev1.ResourceMemory:           resource.MustParse(initContainerMem),
+			corev1.ResourceEphemeralStorage: resource.MustParse(initContainerEphStorage),
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// NetworkPolicies creates the NetworkPolicies of an MPIJob with network
+// isolation, and none without. The first one selects all the pods of the
+// MPIJob, and only allows traffic among them, to DNS and to the egress of the
+// MPIJob. The second one lets the launcher reach the API server or the exec
+// proxy, with the LauncherEgress of the options.
+func NetworkPolicies(mpiJob *v1.MPIJob, opts Options) []*networkingv1.NetworkPolicy {
+	isolation := mpiJob.Spec.NetworkIsolation
+	if isolation == nil {
+		return nil
+	}
+	peers := []networkingv1.NetworkPolicyPeer{
+		{
+			PodSelector: &metav1.LabelSelector{MatchLabels: SelectorLabels(mpiJob)},
+		},
+	}
+	egress := []networkingv1.NetworkPolicyEgressRule{{To: peers}}
+	if isolation.AllowDNS == nil || *isolation.AllowDNS {
+		udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+		port := intstr.FromInt(53)
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &port},
+				{Protocol: &tcp, Port: &port},
+			},
+		})
+	}
+	egress = append(egress, isolation.Egress...)
+
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: getObjectMeta(mpiJob, NetworkPolicySuffix),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: SelectorLabels(mpiJob)},
+				Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: peers}},
+				Egress:      egress,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			},
+		},
+	}
+	if len(opts.LauncherEgress) > 0 {
+		// the preflight pod runs the rsh agent too
+		launchers := metav1.LabelSelector{
+			MatchLabels: SelectorLabels(mpiJob),
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{
+					Key:      LabelJobRole,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   []string{RoleLauncher, RolePreflight},
+				},
+			},
+		}
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: getObjectMeta(mpiJob, LauncherSuffix+NetworkPolicySuffix),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: launchers,
+				Egress:      opts.LauncherEgress,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			},
+		})
+	}
+	return policies
+}
@@ -0,0 +1,75 @@
+package render
+
+import (
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	preflightContainerName         = "preflight"
+	defaultPreflightTimeoutSeconds = 30
+	// preflightDeadlineMarginSeconds is how long the preflight pod may run
+	// besides the timeout of the check, to pull the agent image and read the
+	// workers.
+	preflightDeadlineMarginSeconds = 60
+)
+
+// PreflightPod creates the preflight pod of an MPIJob. It runs the rsh agent
+// from the agent image, with the service account and the credentials of the
+// launcher, to run hostname in every host of the hostfile. The launcher is
+// skipped, as it doesn't exist yet. The pod is stopped if it runs much longer
+// than the timeout, e.g. when an exec hangs.
+func PreflightPod(mpiJob *v1.MPIJob, opts Options) *corev1.Pod {
+	timeout := int32(defaultPreflightTimeoutSeconds)
+	if mpiJob.Spec.Preflight != nil && mpiJob.Spec.Preflight.TimeoutSeconds > 0 {
+		timeout = mpiJob.Spec.Preflight.TimeoutSeconds
+	}
+	container := corev1.Container{
+		Name:            preflightContainerName,
+		Image:           opts.AgentImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{"/" + agentBinaryName, PreflightFlag,
+			"--hostfile", fmt.Sprintf("%s/%s", configMountPath, hostfileName),
+			"--skip", mpiJob.Name + LauncherSuffix,
+			"--timeout", fmt.Sprintf("%ds", timeout)},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      configVolumeName,
+				MountPath: configMountPath,
+			},
+		},
+		Resources: initContainerResources(),
+	}
+	tokenVolume := addAgentCredentials(mpiJob, opts, &container)
+	automount := false
+	deadline := int64(timeout) + preflightDeadlineMarginSeconds
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mpiJob.Name + PreflightSuffix,
+			Namespace: mpiJob.Namespace,
+			Labels:    Labels(mpiJob, RolePreflight),
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName:           mpiJob.Name + LauncherSuffix,
+			AutomountServiceAccountToken: &automount,
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			Containers:                   []corev1.Container{container},
+			Volumes: []corev1.Volume{
+				{
+					Name: configVolumeName,
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: mpiJob.Name + ConfigSuffix,
+							},
+						},
+					},
+				},
+				tokenVolume,
+			},
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPreflightPodDeadline(t *testing.T) {
+	tests := []struct {
+		name         string
+		preflight    *v1.Preflight
+		wantDeadline int64
+	}{
+		{
+			name:         "default timeout",
+			preflight:    &v1.Preflight{},
+			wantDeadline: defaultPreflightTimeoutSeconds + preflightDeadlineMarginSeconds,
+		},
+		{
+			name:         "timeout",
+			preflight:    &v1.Preflight{TimeoutSeconds: 120},
+			wantDeadline: 120 + preflightDeadlineMarginSeconds,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default"}}
+			mpiJob.Spec.Preflight = tt.preflight
+			pod := PreflightPod(mpiJob, Options{AgentImage: "mpi-agent"})
+			deadline := pod.Spec.ActiveDeadlineSeconds
+			if deadline == nil || *deadline != tt.wantDeadline {
+				t.Errorf("got active deadline %v, want %d", deadline, tt.wantDeadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package render
+
+import (
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getObjectMeta(mpiJob *v1.MPIJob, suffix string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      mpiJob.Name + suffix,
+		Namespace: mpiJob.Namespace,
+		Labels:    Labels(mpiJob, ""),
+	}
+}
+
+// LauncherServiceAccount creates a new launcher ServiceAccount for an MPIJob
+// resource. Its token is not mounted automatically, the launcher gets a bound
+// token with a short expiry instead.
+func LauncherServiceAccount(mpiJob *v1.MPIJob) *corev1.ServiceAccount {
+	automount := false
+	return &corev1.ServiceAccount{
+		ObjectMeta:                   getObjectMeta(mpiJob, LauncherSuffix),
+		AutomountServiceAccountToken: &automount,
+	}
+}
+
+// LauncherRole creates a new launcher Role for an MPIJob resource. It only
+// allows the launcher to get and exec into the pods listed in the hostfile,
+// which is what the rsh agent needs, so the launcher can't list the other pods
+// of the namespace. With the exec proxy the launcher doesn't call the API
+// server, and the Role has no rules. Neither has it without any host, as empty
+// resource names would allow all the pods.
+func LauncherRole(mpiJob *v1.MPIJob, opts Options) *rbacv1.Role {
+	podNames := WorkerNames(mpiJob)
+	if mpiJob.Spec.RunLauncherAsWorker {
+		// mpirun may exec into the launcher too, as it is listed in the hostfile
+		podNames = append([]string{mpiJob.Name + LauncherSuffix}, podNames...)
+	}
+	if opts.ExecProxyURL != "" || len(podNames) == 0 {
+		return &rbacv1.Role{
+			ObjectMeta: getObjectMeta(mpiJob, LauncherSuffix),
+		}
+	}
+	return &rbacv1.Role{
+		ObjectMeta: getObjectMeta(mpiJob, LauncherSuffix),
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:         []string{"get"},
+				APIGroups:     []string{""},
+				Resources:     []string{"pods"},
+				ResourceNames: podNames,
+			},
+			{
+				Verbs:         []string{"create"},
+				APIGroups:     []string{""},
+				Resources:     []string{"pods/exec"},
+				ResourceNames: podNames,
+			},
+		},
+	}
+}
+
+// LauncherRoleBinding creates a new launcher RoleBinding for an MPIJob
+// resource.
+func LauncherRoleBinding(mpiJob *v1.MPIJob) *rbacv1.RoleBinding {
+	launcherName := mpiJob.Name + LauncherSuffix
+	return &rbacv1.RoleBinding{
+		ObjectMeta: getObjectMeta(mpiJob, LauncherSuffix),
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      launcherName,
+				Namespace: mpiJob.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     launcherName,
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestLauncherRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       v1.MPIJobSpec
+		opts       Options
+		wantPods   []string
+		wantNoRule bool
+	}{
+		{
+			name:     "workers",
+			spec:     v1.MPIJobSpec{NumWorkers: int32Ptr(2)},
+			wantPods: []string{"job-worker-0", "job-worker-1"},
+		},
+		{
+			name:     "launcher as worker",
+			spec:     v1.MPIJobSpec{NumWorkers: int32Ptr(1), RunLauncherAsWorker: true},
+			wantPods: []string{"job-launcher", "job-worker-0"},
+		},
+		{
+			name:     "launcher as the only worker",
+			spec:     v1.MPIJobSpec{NumWorkers: int32Ptr(0), RunLauncherAsWorker: true},
+			wantPods: []string{"job-launcher"},
+		},
+		{
+			name:       "no workers",
+			spec:       v1.MPIJobSpec{NumWorkers: int32Ptr(0)},
+			wantNoRule: true,
+		},
+		{
+			name: "empty worker groups",
+			spec: v1.MPIJobSpec{WorkerGroups: []v1.WorkerGroup{
+				{Name: "a", Replicas: 0},
+				{Name: "b", Replicas: 0},
+			}},
+			wantNoRule: true,
+		},
+		{
+			name:       "exec proxy",
+			spec:       v1.MPIJobSpec{NumWorkers: int32Ptr(2)},
+			opts:       Options{ExecProxyURL: "https://proxy"},
+			wantNoRule: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+				Spec:       tt.spec,
+			}
+			role := LauncherRole(mpiJob, tt.opts)
+			if tt.wantNoRule {
+				if len(role.Rules) != 0 {
+					t.Fatalf("got rules %v, want none", role.Rules)
+				}
+				return
+			}
+			if len(role.Rules) != 2 {
+				t.Fatalf("got %d rules, want 2", len(role.Rules))
+			}
+			for _, rule := range role.Rules {
+				if len(rule.ResourceNames) == 0 {
+					t.Fatalf("rule %v allows all the pods", rule)
+				}
+				if !equalStrings(rule.ResourceNames, tt.wantPods) {
+					t.Errorf("got resource names %v, want %v", rule.ResourceNames, tt.wantPods)
+				}
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultContainerAnnotation chooses the container of a pod the commands of
+// the rsh agent run in, as for kubectl exec. It is the first container by
+// default.
+const DefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+const defaultReadinessPeriodSeconds = 5
+
+// launcherReadinessName is the init container that holds mpirun until the
+// launcher, when it runs as a worker, passes the readiness command.
+const launcherReadinessName = "worker-readiness"
+
+// ExecContainer returns the index of the container of a pod the commands of
+// the rsh agent run in, or -1 if the pod has no such container.
+func ExecContainer(annotations map[string]string, spec *corev1.PodSpec) int {
+	name := annotations[DefaultContainerAnnotation]
+	if name == "" {
+		if len(spec.Containers) == 0 {
+			return -1
+		}
+		return 0
+	}
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// mpiDaemonCheck checks that the shell the rsh agent runs the commands of
+// mpirun with finds the daemon mpirun starts in every host: orted for Open
+// MPI, hydra_pmi_proxy for MPICH and Intel MPI.
+const mpiDaemonCheck = "command -v orted >/dev/null || command -v hydra_pmi_proxy >/dev/null"
+
+// addReadinessProbe adds the readiness probe of the workers of an MPIJob to
+// the container the rsh agent runs the commands in, unless it is disabled or
+// the container has its own. The probe runs in the container like the
+// commands of the agent, only after the init containers, which fetch the
+// source, are done. It checks that the setup step of the managed entrypoint
+// is done, and that mpirun can start its daemon, or runs the readiness
+// command instead.
+func addReadinessProbe(mpiJob *v1.MPIJob, annotations map[string]string, spec *corev1.PodSpec) {
+	readiness := mpiJob.Spec.WorkerReadiness
+	if readiness == nil {
+		readiness = &v1.WorkerReadiness{}
+	}
+	i := ExecContainer(annotations, spec)
+	if readiness.Disabled || i < 0 || spec.Containers[i].ReadinessProbe != nil {
+		return
+	}
+	var checks []string
+	if managedEntrypoint(mpiJob) {
+		checks = append(checks, "test -e "+workerReadyFile)
+	}
+	if readiness.Command != "" {
+		checks = append(checks, readiness.Command)
+	} else {
+		checks = append(checks, "("+mpiDaemonCheck+")")
+	}
+	command := strings.Join(checks, " && ")
+	period := readiness.PeriodSeconds
+	if period == 0 {
+		period = defaultReadinessPeriodSeconds
+	}
+	spec.Containers[i].ReadinessProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", command}},
+		},
+		PeriodSeconds:  period,
+		TimeoutSeconds: period,
+	}
+}
+
+// addLauncherReadiness holds the launcher of an MPIJob that runs as a worker
+// until it passes the readiness command of the workers, as its local ranks
+// start with mpirun and no probe can gate them. It runs the command in an
+// init container with the image, the environment, the mounts and the resources
+// of the launcher container, after the other init containers.
+func addLauncherReadiness(mpiJob *v1.MPIJob, spec *corev1.PodSpec) {
+	readiness := mpiJob.Spec.WorkerReadiness
+	if !mpiJob.Spec.RunLauncherAsWorker || readiness == nil || readiness.Disabled ||
+		readiness.Command == "" || len(spec.Containers) == 0 {
+		return
+	}
+	period := readiness.PeriodSeconds
+	if period == 0 {
+		period = defaultReadinessPeriodSeconds
+	}
+	container := spec.Containers[0]
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:            launcherReadinessName,
+		Image:           container.Image,
+		ImagePullPolicy: container.ImagePullPolicy,
+		Command: []string{"/bin/sh", "-c",
+			fmt.Sprintf("until /bin/sh -c \"$0\"; do sleep %d; done", period), readiness.Command},
+		Env:          container.Env,
+		EnvFrom:      container.EnvFrom,
+		VolumeMounts: container.VolumeMounts,
+		WorkingDir:   container.WorkingDir,
+		// e.g. the devices the command checks
+		Resources: container.Resources,
+	})
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddReadinessProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		readiness   *v1.WorkerReadiness
+		policy      v1.WorkerCommandPolicy
+		annotations map[string]string
+		probe       *corev1.Probe
+		wantCommand string
+		wantNone    bool
+		wantIndex   int
+	}{
+		{
+			name:        "default",
+			wantCommand: "(" + mpiDaemonCheck + ")",
+		},
+		{
+			name:        "keep-alive entrypoint",
+			policy:      v1.WorkerCommandWrap,
+			wantCommand: "test -e " + workerReadyFile + " && (" + mpiDaemonCheck + ")",
+		},
+		{
+			name:        "command",
+			readiness:   &v1.WorkerReadiness{Command: "test -f /tmp/setup-done"},
+			policy:      v1.WorkerCommandReplace,
+			wantCommand: "test -e " + workerReadyFile + " && test -f /tmp/setup-done",
+		},
+		{
+			name:        "default container",
+			annotations: map[string]string{DefaultContainerAnnotation: "sidecar"},
+			wantCommand: "(" + mpiDaemonCheck + ")",
+			wantIndex:   1,
+		},
+		{
+			name:      "disabled",
+			readiness: &v1.WorkerReadiness{Disabled: true},
+			wantNone:  true,
+		},
+		{
+			name:     "own probe",
+			probe:    &corev1.Probe{PeriodSeconds: 1},
+			wantNone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{Spec: v1.MPIJobSpec{WorkerReadiness: tt.readiness, WorkerCommandPolicy: tt.policy}}
+			spec := &corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "worker", ReadinessProbe: tt.probe},
+				{Name: "sidecar"},
+			}}
+			addReadinessProbe(mpiJob, tt.annotations, spec)
+			probe := spec.Containers[tt.wantIndex].ReadinessProbe
+			if tt.wantNone {
+				if probe != tt.probe {
+					t.Fatalf("got probe %v, want %v", probe, tt.probe)
+				}
+				return
+			}
+			if probe == nil || probe.Exec == nil {
+				t.Fatalf("got probe %v, want an exec probe", probe)
+			}
+			want := []string{"/bin/sh", "-c", tt.wantCommand}
+			if !equalStrings(probe.Exec.Command, want) {
+				t.Errorf("got command %q, want %q", probe.Exec.Command, want)
+			}
+			if probe.PeriodSeconds != defaultReadinessPeriodSeconds {
+				t.Errorf("got period %d, want %d", probe.PeriodSeconds, defaultReadinessPeriodSeconds)
+			}
+		})
+	}
+}
+
+func TestAddLauncherReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      v1.MPIJobSpec
+		wantCheck bool
+	}{
+		{
+			name: "launcher as worker with a command",
+			spec: v1.MPIJobSpec{RunLauncherAsWorker: true,
+				WorkerReadiness: &v1.WorkerReadiness{Command: "nvidia-smi"}},
+			wantCheck: true,
+		},
+		{
+			name: "launcher as worker without a command",
+			spec: v1.MPIJobSpec{RunLauncherAsWorker: true},
+		},
+		{
+			name: "launcher as worker with the readiness disabled",
+			spec: v1.MPIJobSpec{RunLauncherAsWorker: true,
+				WorkerReadiness: &v1.WorkerReadiness{Command: "nvidia-smi", Disabled: true}},
+		},
+		{
+			name: "launcher only",
+			spec: v1.MPIJobSpec{WorkerReadiness: &v1.WorkerReadiness{Command: "nvidia-smi"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{Spec: tt.spec}
+			spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "launcher", Image: "mpi"}}}
+			addLauncherReadiness(mpiJob, spec)
+			if !tt.wantCheck {
+				if len(spec.InitContainers) != 0 {
+					t.Fatalf("got init containers %v, want none", spec.InitContainers)
+				}
+				return
+			}
+			if len(spec.InitContainers) != 1 {
+				t.Fatalf("got %d init containers, want 1", len(spec.InitContainers))
+			}
+			check := spec.InitContainers[0]
+			if check.Name != launcherReadinessName || check.Image != "mpi" {
+				t.Errorf("got init container %s with image %s", check.Name, check.Image)
+			}
+			if n := len(check.Command); n == 0 || check.Command[n-1] != "nvidia-smi" {
+				t.Errorf("got command %q, want it to run nvidia-smi", check.Command)
+			}
+		})
+	}
+}
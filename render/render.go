@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render builds the child objects the controller creates for an
+// MPIJob. The functions only depend on the MPIJob, so the objects can be
+// reviewed without a cluster.
+package render
+
+import (
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ConfigSuffix        = "-config"
+	LauncherSuffix      = "-launcher"
+	WorkerSuffix        = "-worker"
+	NetworkPolicySuffix = "-network"
+	WorkspaceSuffix     = "-workspace"
+	PreflightSuffix     = "-preflight"
+
+	// DefaultAgentImage is the image of the init container that copies the
+	// rsh agent, cmd/mpi-agent, into the launcher.
+	DefaultAgentImage = "farawaya/mpi-agent"
+
+	// DefaultSourceImage is the image of the init container that fetches the
+	// source of an MPIJob. It has git, wget and tar.
+	DefaultSourceImage = "alpine/git"
+
+	// ExecTokenSuffix is the suffix of the Secret with the exec token of an
+	// MPIJob, used by the launcher to authenticate to the exec proxy.
+	ExecTokenSuffix = "-exec-token"
+	// ExecTokenKey is the key of the token in the Secret.
+	ExecTokenKey = "token"
+	// ExecTokenMountPath is where the exec token is mounted in the launcher.
+	ExecTokenMountPath = "/etc/mpi-exec"
+
+	// The environment of the launcher when the exec proxy is used.
+	ExecProxyURLEnv = "MPI_EXEC_PROXY_URL"
+	ExecProxyCAEnv  = "MPI_EXEC_PROXY_CA"
+	JobNameEnv      = "MPI_JOB_NAME"
+	JobNamespaceEnv = "MPI_JOB_NAMESPACE"
+
+	// PreflightFlag runs the rsh agent as the preflight check.
+	PreflightFlag = "--preflight"
+
+	// LauncherTokenExpirationSeconds is the lifetime of the service account
+	// token of the launcher, the shortest the API server allows.
+	LauncherTokenExpirationSeconds = 600
+
+	configVolumeName          = "mpi-job-config"
+	configMountPath           = "/etc/mpi"
+	hostfileName              = "hostfile"
+	agentDeliveryName         = "mpi-agent-delivery"
+	agentBinaryName           = "mpi-agent"
+	agentVolumeName           = "mpi-job-agent"
+	agentMountPath            = "/opt/mpi-agent"
+	tokenVolumeName           = "mpi-job-token"
+	tokenMountPath            = "/var/run/secrets/kubernetes.io/serviceaccount"
+	execTokenVolumeName       = "mpi-job-exec-token"
+	workspaceVolumeName       = "mpi-job-workspace"
+	sourceCommitVolumeName    = "mpi-job-source-commit"
+	sourceCommitMountPath     = "/etc/mpi-source"
+	sourceCommitName          = "source-commit"
+	defaultWorkspaceMountPath = "/workspace"
+	sourceFetchName           = "mpi-source-fetch"
+	sourceVolumeName          = "mpi-job-source"
+	defaultSourceMountPath    = "/src"
+	initContainerCpu          = "100m"
+	initContainerEphStorage   = "5Gi"
+	initContainerMem          = "512Mi"
+)
+
+// Options are the settings of the manager the child objects depend on.
+type Options struct {
+	// AgentImage is the image that delivers the rsh agent to the launcher.
+	AgentImage string
+	// ExecProxyURL is the URL of the exec proxy of the manager. When set, the
+	// rsh agent runs the commands in the workers through the proxy, and the
+	// launcher has no permission on the API server.
+	ExecProxyURL string
+	// ExecProxyCA is the PEM bundle the rsh agent verifies the certificate
+	// of the exec proxy with, besides the system roots.
+	ExecProxyCA string
+	// LauncherEgress are the egress rules the launcher needs with network
+	// isolation, to reach the API server or the exec proxy. They depend on
+	// the cluster, and are set by the controller.
+	LauncherEgress []networkingv1.NetworkPolicyEgressRule
+}
+
+// Objects returns all the child objects of an MPIJob, in the order the
+// controller creates them: the ConfigMap, the launcher ServiceAccount, Role
+// and RoleBinding, the workspace PersistentVolumeClaim, the NetworkPolicies
+// with network isolation, the exec token Secret when the exec proxy is used,
+// the workers, the preflight pod when the MPIJob has a preflight check, and
+// the launcher. The workers are StatefulSets, or Pods in the Pods worker
+// mode. The OwnerReferences are not set.
+func Objects(mpiJob *v1.MPIJob, opts Options) ([]client.Object, error) {
+	objs := []client.Object{
+		ConfigMap(mpiJob),
+		LauncherServiceAccount(mpiJob),
+		LauncherRole(mpiJob, opts),
+		LauncherRoleBinding(mpiJob),
+	}
+	if claim := WorkspaceClaim(mpiJob); claim != nil {
+		objs = append(objs, claim)
+	}
+	for _, policy := range NetworkPolicies(mpiJob, opts) {
+		objs = append(objs, policy)
+	}
+	if opts.ExecProxyURL != "" {
+		secret, err := ExecTokenSecret(mpiJob)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, secret)
+	}
+	groups := WorkerGroups(mpiJob)
+	for g := range groups {
+		if mpiJob.Spec.WorkerMode != v1.WorkerModePods {
+			objs = append(objs, Worker(mpiJob, &groups[g]))
+			continue
+		}
+		for i := 0; i < int(groups[g].Replicas); i++ {
+			objs = append(objs, WorkerPod(mpiJob, &groups[g], i))
+		}
+	}
+	if mpiJob.Spec.Preflight != nil {
+		objs = append(objs, PreflightPod(mpiJob, opts))
+	}
+	launcher, err := Launcher(mpiJob, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(objs, launcher), nil
+}
@@ -0,0 +1,185 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The environment of the source fetch init container.
+const (
+	sourceURLEnv      = "MPI_SOURCE_URL"
+	sourceRevisionEnv = "MPI_SOURCE_REVISION"
+	sourceDirEnv      = "MPI_SOURCE_DIR"
+)
+
+// gitFetchScript clones the repository, unless a previous run of the init
+// container already did, and checks out the commit pinned in the ConfigMap, or
+// else the revision, or the HEAD of the repository without revision. The
+// commit checked out is reported in the termination message.
+const gitFetchScript = `set -e
+if [ ! -d "$MPI_SOURCE_DIR/.git" ]; then
+  git clone --no-checkout "$MPI_SOURCE_URL" "$MPI_SOURCE_DIR"
+fi
+cd "$MPI_SOURCE_DIR"
+revision="${MPI_SOURCE_REVISION:-origin/HEAD}"
+if [ -s ` + sourceCommitMountPath + `/` + sourceCommitName + ` ]; then
+  revision="$(cat ` + sourceCommitMountPath + `/` + sourceCommitName + `)"
+fi
+git -c advice.detachedHead=false checkout --force "$revision"
+git rev-parse HEAD | tee ` + corev1.TerminationMessagePathDefault + `
+`
+
+// archiveFetchScript downloads and extracts the archive.
+const archiveFetchScript = `set -e -o pipefail
+wget -qO- "$MPI_SOURCE_URL" | tar -xzf - -C "$MPI_SOURCE_DIR"
+`
+
+var commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// IsCommit reports whether a git revision is a full commit hash.
+func IsCommit(revision string) bool {
+	return commitPattern.MatchString(revision)
+}
+
+// SourceCommit returns the commit the git source of an MPIJob is pinned to in
+// the status, if it was pinned for the current source.
+func SourceCommit(mpiJob *v1.MPIJob) string {
+	source := mpiJob.Spec.Source
+	if source == nil || source.Git == nil {
+		return ""
+	}
+	status := mpiJob.Status.Source
+	if status != nil && status.URL == source.Git.URL && status.Revision == source.Git.Revision {
+		return status.Commit
+	}
+	return ""
+}
+
+// FetchedCommit returns the commit a pod checked out for the current git
+// source of an MPIJob, as reported by its source fetch init container once it
+// has succeeded.
+func FetchedCommit(mpiJob *v1.MPIJob, pod *corev1.Pod) string {
+	source := mpiJob.Spec.Source
+	if source == nil || source.Git == nil {
+		return ""
+	}
+	var fetch *corev1.Container
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == sourceFetchName {
+			fetch = &pod.Spec.InitContainers[i]
+		}
+	}
+	if fetch == nil {
+		return ""
+	}
+	// the pod may have been created for a previous source
+	env := map[string]string{}
+	for _, e := range fetch.Env {
+		env[e.Name] = e.Value
+	}
+	if env[sourceURLEnv] != source.Git.URL || env[sourceRevisionEnv] != source.Git.Revision {
+		return ""
+	}
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name != sourceFetchName || cs.State.Terminated == nil || cs.State.Terminated.ExitCode != 0 {
+			continue
+		}
+		if commit := strings.TrimSpace(cs.State.Terminated.Message); IsCommit(commit) {
+			return commit
+		}
+	}
+	return ""
+}
+
+// addSource mounts the source of an MPIJob, if any, in all the containers and
+// init containers of a pod. The source is fetched by an init container that
+// runs before the init containers of the template, so they can use it too. A
+// ConfigMap source is mounted directly.
+func addSource(mpiJob *v1.MPIJob, spec *corev1.PodSpec) {
+	source := mpiJob.Spec.Source
+	if source == nil {
+		return
+	}
+	mountPath := source.MountPath
+	if mountPath == "" {
+		mountPath = defaultSourceMountPath
+	}
+	mount := corev1.VolumeMount{
+		Name:      sourceVolumeName,
+		MountPath: mountPath,
+	}
+	volume := corev1.Volume{
+		Name: sourceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+	if source.ConfigMap != nil {
+		mount.ReadOnly = true
+		volume.VolumeSource = corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: source.ConfigMap.Name},
+			},
+		}
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].VolumeMounts = append(spec.InitContainers[i].VolumeMounts, mount)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+	}
+
+	var url, revision, script string
+	switch {
+	case source.Git != nil:
+		url, revision, script = source.Git.URL, source.Git.Revision, gitFetchScript
+	case source.Archive != nil:
+		url, script = source.Archive.URL, archiveFetchScript
+	default:
+		return
+	}
+	image := source.Image
+	if image == "" {
+		image = DefaultSourceImage
+	}
+	fetch := corev1.Container{
+		Name:            sourceFetchName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{Name: sourceURLEnv, Value: url},
+			{Name: sourceRevisionEnv, Value: revision},
+			{Name: sourceDirEnv, Value: mountPath},
+		},
+		VolumeMounts:             []corev1.VolumeMount{mount},
+		Resources:                initContainerResources(),
+		TerminationMessagePath:   corev1.TerminationMessagePathDefault,
+		TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+	}
+	if source.Git != nil {
+		// The pinned commit is read from the ConfigMap rather than set in the
+		// template, so pinning it doesn't change the worker StatefulSets.
+		optional := true
+		fetch.VolumeMounts = append(fetch.VolumeMounts, corev1.VolumeMount{
+			Name:      sourceCommitVolumeName,
+			MountPath: sourceCommitMountPath,
+			ReadOnly:  true,
+		})
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: sourceCommitVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: mpiJob.Name + ConfigSuffix},
+					Items:                []corev1.KeyToPath{{Key: sourceCommitName, Path: sourceCommitName}},
+					Optional:             &optional,
+				},
+			},
+		})
+	}
+	spec.InitContainers = append([]corev1.Container{fetch}, spec.InitContainers...)
+}
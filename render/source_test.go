@@ -0,0 +1,90 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testCommit = "0123456789abcdef0123456789abcdef01234567"
+
+func TestIsCommit(t *testing.T) {
+	tests := []struct {
+		revision string
+		want     bool
+	}{
+		{revision: testCommit, want: true},
+		{revision: testCommit + testCommit[:24], want: true},
+		{revision: testCommit[:7]},
+		{revision: "0123456789ABCDEF0123456789ABCDEF01234567"},
+		{revision: "main"},
+		{revision: ""},
+	}
+	for _, tt := range tests {
+		if got := IsCommit(tt.revision); got != tt.want {
+			t.Errorf("IsCommit(%q) = %v, want %v", tt.revision, got, tt.want)
+		}
+	}
+}
+
+func TestFetchedCommit(t *testing.T) {
+	terminated := func(code int32, message string) []corev1.ContainerStatus {
+		return []corev1.ContainerStatus{{
+			Name:  sourceFetchName,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: code, Message: message}},
+		}}
+	}
+	tests := []struct {
+		name     string
+		podRev   string
+		specRev  string
+		statuses []corev1.ContainerStatus
+		want     string
+	}{
+		{
+			name:     "fetched",
+			statuses: terminated(0, testCommit+"\n"),
+			want:     testCommit,
+		},
+		{
+			name: "running",
+			statuses: []corev1.ContainerStatus{{
+				Name:  sourceFetchName,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+		{
+			name:     "failed",
+			statuses: terminated(1, "fatal: couldn't find remote ref"),
+		},
+		{
+			name:     "not a commit",
+			statuses: terminated(0, testCommit[:7]),
+		},
+		{
+			name:     "pod of a previous revision",
+			podRev:   "v1",
+			specRev:  "v2",
+			statuses: terminated(0, testCommit),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+				Spec: v1.MPIJobSpec{
+					NumWorkers: int32Ptr(1),
+					Source:     &v1.Source{Git: &v1.GitSource{URL: "https://git/repo", Revision: tt.podRev}},
+				},
+			}
+			pod := WorkerPod(mpiJob, &WorkerGroups(mpiJob)[0], 0)
+			pod.Status.InitContainerStatuses = tt.statuses
+			mpiJob.Spec.Source.Git.Revision = tt.specRev
+			if got := FetchedCommit(mpiJob, pod); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package render
+
+import (
+	"fmt"
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkerGroups returns the worker groups of an MPIJob in rank order. An MPIJob
+// without WorkerGroups has a single unnamed group built from WorkerTemplate
+// and NumWorkers, unless it has no workers, e.g. when the launcher is the only
+// host: the WorkerTemplate is then usually empty.
+func WorkerGroups(mpiJob *v1.MPIJob) []v1.WorkerGroup {
+	if len(mpiJob.Spec.WorkerGroups) > 0 {
+		return mpiJob.Spec.WorkerGroups
+	}
+	var replicas int32
+	if mpiJob.Spec.NumWorkers != nil {
+		replicas = *mpiJob.Spec.NumWorkers
+	}
+	if replicas == 0 {
+		return nil
+	}
+	return []v1.WorkerGroup{
+		{
+			Template: mpiJob.Spec.WorkerTemplate,
+			Replicas: replicas,
+			Slots:    1,
+		},
+	}
+}
+
+// NumWorkers returns the number of workers of all worker groups of an MPIJob.
+func NumWorkers(mpiJob *v1.MPIJob) int32 {
+	var n int32
+	for _, group := range WorkerGroups(mpiJob) {
+		n += group.Replicas
+	}
+	return n
+}
+
+// WorkerGroupName returns the name of the StatefulSet of a worker group,
+// which is also the prefix of its pod names.
+func WorkerGroupName(mpiJob *v1.MPIJob, group *v1.WorkerGroup) string {
+	if group.Name == "" {
+		return mpiJob.Name + WorkerSuffix
+	}
+	return mpiJob.Name + WorkerSuffix + "-" + group.Name
+}
+
+// WorkerName returns the name of the i-th worker pod of a group. The
+// StatefulSet and the Pods worker modes use the same names, so the hostfile
+// and the launcher Role don't depend on the mode.
+func WorkerName(mpiJob *v1.MPIJob, group *v1.WorkerGroup, i int) string {
+	return fmt.Sprintf("%s-%d", WorkerGroupName(mpiJob, group), i)
+}
+
+// WorkerNames returns the names of all worker pods of an MPIJob in rank order.
+func WorkerNames(mpiJob *v1.MPIJob) []string {
+	var names []string
+	groups := WorkerGroups(mpiJob)
+	for g := range groups {
+		for i := 0; i < int(groups[g].Replicas); i++ {
+			names = append(names, WorkerName(mpiJob, &groups[g], i))
+		}
+	}
+	return names
+}
+
+// Worker creates the StatefulSet of a worker group of an MPIJob. Its pods are
+// only updated when the controller deletes them, so a running launcher doesn't
+// lose its workers to a rolling update.
+func Worker(mpiJob *v1.MPIJob, group *v1.WorkerGroup) *appsv1.StatefulSet {
+	name := WorkerGroupName(mpiJob, group)
+	replicas := group.Replicas
+	if WorkersReleased(mpiJob) {
+		replicas = 0
+	}
+	template := *group.Template.DeepCopy()
+	// the replica index is added to the pods by the controller
+	template.Labels = mergeLabels(template.Labels, WorkerLabels(mpiJob, group))
+	template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	addWorkspace(mpiJob, &template.Spec)
+	addAttemptEnv(mpiJob, &template.Spec)
+	addSource(mpiJob, &template.Spec)
+	addWorkerEntrypoint(mpiJob, template.Annotations, &template.Spec)
+	addReadinessProbe(mpiJob, template.Annotations, &template.Spec)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: mpiJob.Namespace,
+			Labels:    Labels(mpiJob, RoleWorker),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: WorkerLabels(mpiJob, group),
+			},
+			Template:            template,
+			ServiceName:         mpiJob.Name,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.OnDeleteStatefulSetStrategyType,
+			},
+		},
+	}
+}
+
+// WorkerPod creates the i-th worker Pod of a worker group of an MPIJob
+// running in Pods worker mode. Unlike Worker, the RestartPolicy of the
+// template is kept, because a Pod that ends is recreated by the controller.
+// Only Always becomes OnFailure with the keep-alive entrypoint, so the Pod
+// ends when the entrypoint exits with the MPIJob.
+func WorkerPod(mpiJob *v1.MPIJob, group *v1.WorkerGroup, i int) *corev1.Pod {
+	template := group.Template.DeepCopy()
+	if managedEntrypoint(mpiJob) &&
+		(template.Spec.RestartPolicy == "" || template.Spec.RestartPolicy == corev1.RestartPolicyAlways) {
+		template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	}
+	template.Labels = mergeLabels(template.Labels, WorkerLabels(mpiJob, group))
+	template.Labels[LabelReplicaIndex] = ReplicaIndex(mpiJob, group, i)
+	name := WorkerName(mpiJob, group, i)
+	// match the hostname a StatefulSet pod would get
+	template.Spec.Hostname = name
+	addWorkspace(mpiJob, &template.Spec)
+	addAttemptEnv(mpiJob, &template.Spec)
+	addSource(mpiJob, &template.Spec)
+	addWorkerEntrypoint(mpiJob, template.Annotations, &template.Spec)
+	addReadinessProbe(mpiJob, template.Annotations, &template.Spec)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   mpiJob.Namespace,
+			Labels:      template.Labels,
+			Annotations: template.Annotations,
+		},
+		Spec: template.Spec,
+	}
+}
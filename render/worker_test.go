@@ -0,0 +1,98 @@
+package render
+
+import (
+	"testing"
+
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWorkerPodRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy v1.WorkerCommandPolicy
+		in     corev1.RestartPolicy
+		want   corev1.RestartPolicy
+	}{
+		{name: "keep", policy: v1.WorkerCommandKeep, in: corev1.RestartPolicyAlways, want: corev1.RestartPolicyAlways},
+		{name: "keep unset", policy: v1.WorkerCommandKeep, in: "", want: ""},
+		{name: "replace unset", policy: v1.WorkerCommandReplace, in: "", want: corev1.RestartPolicyOnFailure},
+		{name: "wrap always", policy: v1.WorkerCommandWrap, in: corev1.RestartPolicyAlways, want: corev1.RestartPolicyOnFailure},
+		{name: "wrap never", policy: v1.WorkerCommandWrap, in: corev1.RestartPolicyNever, want: corev1.RestartPolicyNever},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+				Spec: v1.MPIJobSpec{
+					NumWorkers:          int32Ptr(1),
+					WorkerCommandPolicy: tt.policy,
+					WorkerTemplate: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+						RestartPolicy: tt.in,
+						Containers:    []corev1.Container{{Name: "worker"}},
+					}},
+				},
+			}
+			pod := WorkerPod(mpiJob, &WorkerGroups(mpiJob)[0], 0)
+			if pod.Spec.RestartPolicy != tt.want {
+				t.Errorf("got restart policy %q, want %q", pod.Spec.RestartPolicy, tt.want)
+			}
+			if pod.Spec.Hostname != "job-worker-0" {
+				t.Errorf("got hostname %q, want job-worker-0", pod.Spec.Hostname)
+			}
+		})
+	}
+}
+
+func TestWorkerGroups(t *testing.T) {
+	tests := []struct {
+		name             string
+		spec             v1.MPIJobSpec
+		wantNames        []string
+		wantStatefulSets int
+	}{
+		{
+			name:             "workers",
+			spec:             v1.MPIJobSpec{NumWorkers: int32Ptr(2)},
+			wantNames:        []string{"job-worker-0", "job-worker-1"},
+			wantStatefulSets: 1,
+		},
+		{
+			name: "launcher only",
+			spec: v1.MPIJobSpec{RunLauncherAsWorker: true},
+		},
+		{
+			name: "no workers",
+			spec: v1.MPIJobSpec{NumWorkers: int32Ptr(0), RunLauncherAsWorker: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.LauncherTemplate = corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "launcher"}},
+			}}
+			mpiJob := &v1.MPIJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "ns"},
+				Spec:       tt.spec,
+			}
+			if names := WorkerNames(mpiJob); !equalStrings(names, tt.wantNames) {
+				t.Errorf("got workers %v, want %v", names, tt.wantNames)
+			}
+			objs, err := Objects(mpiJob, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			statefulSets := 0
+			for _, obj := range objs {
+				if _, ok := obj.(*appsv1.StatefulSet); ok {
+					statefulSets++
+				}
+			}
+			if statefulSets != tt.wantStatefulSets {
+				t.Errorf("got %d StatefulSets, want %d", statefulSets, tt.wantStatefulSets)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	v1 "github.com/FFFFFaraway/MPI-Operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WorkspaceClaim creates the PersistentVolumeClaim of the workspace of an
+// MPIJob, or returns nil if the MPIJob has no workspace.
+func WorkspaceClaim(mpiJob *v1.MPIJob) *corev1.PersistentVolumeClaim {
+	workspace := mpiJob.Spec.Workspace
+	if workspace == nil {
+		return nil
+	}
+	spec := *workspace.VolumeClaimSpec.DeepCopy()
+	if len(spec.AccessModes) == 0 {
+		spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	}
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: getObjectMeta(mpiJob, WorkspaceSuffix),
+		Spec:       spec,
+	}
+}
+
+// addWorkspace mounts the workspace of an MPIJob, if any, in all the
+// containers and init containers of a pod.
+func addWorkspace(mpiJob *v1.MPIJob, spec *corev1.PodSpec) {
+	workspace := mpiJob.Spec.Workspace
+	if workspace == nil {
+		return
+	}
+	mountPath := workspace.MountPath
+	if mountPath == "" {
+		mountPath = defaultWorkspaceMountPath
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: workspaceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: mpiJob.Name + WorkspaceSuffix,
+			},
+		},
+	})
+	mount := corev1.VolumeMount{
+		Name:      workspaceVolumeName,
+		MountPath: mountPath,
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].VolumeMounts = append(spec.InitContainers[i].VolumeMounts, mount)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+	}
+}